@@ -24,7 +24,11 @@ By default, it will generated a file called file_data.go:
 	func NewFileSystem() (*filesystem.FileSystem, error) {
 		fs := filesystem.New()
 
-		if err := fs.Add("some_file", SIZE, os.FileMode(MODE), time.Unix(TIMESTAMP, 0), "DATA"); err != nil {
+		if err := fs.AddDir("a/directory", os.FileMode(MODE), time.Unix(TIMESTAMP, 0)); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("packing directory a/directory"))
+		}
+
+		if err := fs.Add("some_file", SIZE, os.FileMode(MODE), time.Unix(TIMESTAMP, 0), "DATA", filesystem.SHA256("HASH")); err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("packing file some_file"))
 		}
 
@@ -34,7 +38,58 @@ By default, it will generated a file called file_data.go:
 	}
 
 The output file, function and package names, as well as build tags can be set
-via flags.
+via flags. The -fallback flag makes the filesystem fall back to the operating
+system for files that weren't embedded, and -fallback-dir DIR roots that
+fallback at the given directory. With -compress, file data is stored gzip compressed
+whenever that reduces its size, and is decompressed on demand. The
+-fingerprint flag also adds each file under a name containing a hash of its
+content, e.g. app.3f9a1c2b.js, and records it in the filesystem's manifest,
+so that FileSystem.AssetPath can resolve it at runtime.
+
+Symbolic links to files are read through by default, duplicating the data of
+their targets, while links to directories are skipped. With
+-preserve-symlinks, links are added as links instead, via
+FileSystem.AddSymlink, and are resolved when the files are opened.
+
+The content type of each file is detected at generation time from its
+extension or data, and recorded for the filesystem's Handler. It may be set
+explicitly with -content-type PATTERN=TYPE flags, matched against the base
+name of each file, or by ending a line of an -input file with
+content-type=TYPE.
+
+With many files, the generated Add calls slow down both the compiler and the
+program's startup. The -pack flag instead stores all file data in a single
+string constant, in the pack format of filesystem.WritePack, which the
+generated function loads with filesystem.LoadPack. Files in a pack are only
+looked up when opened.
+
+To avoid recompiling the program when the files change, -pack-file FILE
+writes a standalone pack instead of Go source. It can be shipped beside the
+program and opened with filesystem.OpenPack, or appended to the built
+executable and opened with filesystem.OpenSelf:
+
+	embed -pack-file assets.pack assets/...
+	cat assets.pack >> app
+
+With -seal, the generated functions seal the filesystems they create with
+FileSystem.Seal, so that they can be read without locking, but no longer be
+modified.
+
+During development, -dev-output FILE also writes a second file, selected with
+the "dev" build tag, or the one given by -dev-tag, while the main output is
+excluded by it. Its function has the same name, but the filesystem it creates
+reads the files live from the directories they were embedded from, via
+filesystem.Source, so that they may be edited without regenerating and
+rebuilding the program:
+
+	embed -output assets.go -dev-output assets_dev.go assets/...
+	go run -tags dev .
+
+The source directory is recorded relative to that of the development file,
+and is resolved at run time from the file's location, as reported by
+runtime.Caller. Programs built with -trimpath therefore can't locate it.
+Absolute names, and ones starting with "..", are served under the same names
+as in the embedding build.
 
 */
 package main
@@ -3,37 +3,66 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/urandom/embed/filesystem"
 )
 
 var (
-	output       string
-	input        string
-	functionName string
-	packageName  string
-	buildTags    string
-	fatal        bool
-	fallback     bool
-	verbose      bool
+	output        string
+	input         string
+	functionName  string
+	packageName   string
+	buildTags     string
+	fatal         bool
+	compress      bool
+	fingerprint   bool
+	packed        bool
+	packFile      string
+	preserveLinks bool
+	devOutput     string
+	devTag        string
+	seal          bool
+	fallback      bool
+	fallbackDir   string
+	contentTypes  contentTypeFlag
+	verbose       bool
+
+	// inputTypes holds the content types specified in the input file.
+	inputTypes = map[string]string{}
 )
 
 func main() {
 	flag.Parse()
 
-	if flag.NArg() == 0 && !fallback && input == "" {
+	if flag.NArg() == 0 && !fallback && fallbackDir == "" && input == "" {
 		flag.Usage()
 		os.Exit(2)
 	}
 
+	names := flag.Args()
+	if input != "" {
+		names = processInput(input)
+	}
+
+	if packFile != "" {
+		writePackFile(packFile, names, fatal, verbose)
+		return
+	}
+
 	var out io.WriteCloser
 	var err error
 
@@ -46,12 +75,17 @@ func main() {
 		}
 	}
 
-	names := flag.Args()
-	if input != "" {
-		names = processInput(input)
+	h := header{packageName, functionName, buildTags, fallback, fallbackDir, seal}
+	if devOutput != "" {
+		writeDevFile(devOutput, h, names)
+		h.Tags = constrain(buildTags, "!"+devTag)
 	}
 
-	writeData(out, header{packageName, functionName, buildTags, fallback}, names, fatal, verbose)
+	if packed {
+		writePack(out, h, names, fatal, verbose)
+	} else {
+		writeData(out, h, names, fatal, verbose)
+	}
 }
 
 func processInput(input string) []string {
@@ -98,7 +132,13 @@ func processInput(input string) []string {
 			continue
 		}
 
-		names = append(names, string(buf))
+		name := string(buf)
+		if i := strings.LastIndex(name, " content-type="); i != -1 {
+			inputTypes[strings.TrimSpace(name[:i])] = strings.TrimSpace(name[i+len(" content-type="):])
+			name = strings.TrimSpace(name[:i])
+		}
+
+		names = append(names, name)
 
 		if end {
 			break
@@ -117,9 +157,11 @@ func writeData(w io.WriteCloser, h header, names []string, fatal, verbose bool)
 
 	buf := bytes.Buffer{}
 
+	var manifest []file
+
 	defer func() {
 		buf.Reset()
-		err := footerTmpl.Execute(&buf, nil)
+		err := footerTmpl.Execute(&buf, footer{h.Seal, manifest})
 		if err != nil {
 			log.Fatalf("executing footer template: %+v\n", err)
 		}
@@ -153,8 +195,15 @@ func writeData(w io.WriteCloser, h header, names []string, fatal, verbose bool)
 				headerWritten = true
 			}
 
+			tmpl := fileTmpl
+			if f.Dir {
+				tmpl = dirTmpl
+			} else if f.Link != "" {
+				tmpl = linkTmpl
+			}
+
 			buf.Reset()
-			err := fileTmpl.Execute(&buf, f)
+			err := tmpl.Execute(&buf, f)
 			if err != nil {
 				log.Printf("executing file template: %+v\n", err)
 				if fatal {
@@ -163,6 +212,10 @@ func writeData(w io.WriteCloser, h header, names []string, fatal, verbose bool)
 			}
 
 			buf.WriteTo(w)
+
+			if f.Fingerprint != "" {
+				manifest = append(manifest, f)
+			}
 		}
 	}
 
@@ -177,25 +230,213 @@ func writeData(w io.WriteCloser, h header, names []string, fatal, verbose bool)
 	}
 }
 
+// writePack writes a file whose function loads all file data from a single
+// pack, stored as a string constant, instead of adding every file separately.
+func writePack(w io.WriteCloser, h header, names []string, fatal, verbose bool) {
+	defer func() {
+		if err := w.Close(); err != nil {
+			log.Fatalf("closing output file: %+v\n", err)
+		}
+	}()
+
+	fs, err := buildFileSystem(names, fatal, verbose)
+	if err != nil {
+		log.Printf("building filesystem: %+v\n", err)
+		if fatal {
+			return
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := fs.WritePack(&buf); err != nil {
+		log.Fatalf("writing pack: %+v\n", err)
+	}
+
+	data := fmt.Sprintf("%q", buf.Bytes())
+
+	buf.Reset()
+	if err := packTmpl.Execute(&buf, pack{h, data}); err != nil {
+		log.Fatalf("executing pack template: %+v\n", err)
+	}
+
+	buf.WriteTo(w)
+}
+
+// writePackFile writes a standalone pack to the named file, to be opened with
+// filesystem.OpenPack, or appended to an executable and opened with
+// filesystem.OpenSelf.
+func writePackFile(name string, names []string, fatal, verbose bool) {
+	fs, err := buildFileSystem(names, fatal, verbose)
+	if err != nil {
+		log.Printf("building filesystem: %+v\n", err)
+		if fatal {
+			return
+		}
+	}
+
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatalf("opening %s for writing: %+v\n", name, err)
+	}
+
+	w := bufio.NewWriter(out)
+	if err := fs.WritePack(w); err != nil {
+		log.Fatalf("writing pack: %+v\n", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatalf("writing pack: %+v\n", err)
+	}
+
+	if err := out.Close(); err != nil {
+		log.Fatalf("closing pack file: %+v\n", err)
+	}
+}
+
+// writeDevFile writes a file, selected with the development build tag, whose
+// function creates a filesystem that reads the named files from the current
+// directory, instead of embedding them. The directory is recorded relative
+// to that of the written file.
+func writeDevFile(name string, h header, names []string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("getting working directory: %+v\n", err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(name))
+	if err != nil {
+		log.Fatalf("resolving %s: %+v\n", name, err)
+	}
+
+	root, err := filepath.Rel(dir, wd)
+	if err != nil {
+		log.Fatalf("relating %s to %s: %+v\n", wd, dir, err)
+	}
+
+	d := dev{header: h, Root: filepath.ToSlash(root)}
+	d.Tags = constrain(h.Tags, devTag)
+	for _, n := range names {
+		d.Names = append(d.Names, filepath.ToSlash(n))
+	}
+
+	buf := bytes.Buffer{}
+	if err := devTmpl.Execute(&buf, d); err != nil {
+		log.Fatalf("executing dev template: %+v\n", err)
+	}
+
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("writing %s: %+v\n", name, err)
+	}
+}
+
+// constrain adds the tag to every option of the +build expression, so that
+// the tag is required along with the existing constraint.
+func constrain(tags, tag string) string {
+	options := strings.Fields(tags)
+	if len(options) == 0 {
+		return tag
+	}
+
+	for i := range options {
+		options[i] += "," + tag
+	}
+
+	return strings.Join(options, " ")
+}
+
+// buildFileSystem adds the named files to a new filesystem. Unless fatal is
+// set, files that can't be added are skipped, and the first such error is
+// returned along with the filesystem.
+func buildFileSystem(names []string, fatal, verbose bool) (*filesystem.FileSystem, error) {
+	errChan := make(chan error)
+	go func() {
+		for err := range errChan {
+			if verbose {
+				log.Printf("processing file: %+v\n", err)
+			}
+		}
+	}()
+
+	fs := filesystem.New()
+
+	var manifest []file
+	var firstErr error
+
+	for _, name := range names {
+		for f := range processFile(name, errChan) {
+			var err error
+			if f.Dir {
+				err = fs.AddDir(f.Name, os.FileMode(f.Mode), time.Unix(f.ModTime, 0))
+			} else if f.Link != "" {
+				err = fs.AddSymlink(f.Name, f.Link, time.Unix(f.ModTime, 0))
+			} else {
+				options := []filesystem.Option{filesystem.SHA256(f.Hash), filesystem.ContentType(f.ContentType)}
+				if f.Compressed {
+					options = append(options, filesystem.Gzipped())
+				}
+
+				err = fs.Add(f.Name, f.Size, os.FileMode(f.Mode), time.Unix(f.ModTime, 0), string(f.raw), options...)
+			}
+
+			if err != nil {
+				if fatal {
+					return fs, err
+				} else if firstErr == nil {
+					firstErr = err
+				}
+			}
+
+			if f.Fingerprint != "" {
+				manifest = append(manifest, f)
+			}
+		}
+	}
+
+	for _, f := range manifest {
+		if err := fs.AddFingerprint(f.Name, f.Fingerprint); err != nil {
+			if fatal {
+				return fs, err
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return fs, firstErr
+}
+
 func processFile(name string, errChan chan<- error) <-chan file {
 	fileChan := make(chan file)
 
 	go func() {
 		defer close(fileChan)
 
+		override := inputTypes[name]
+
 		var recursive bool
 		if strings.HasSuffix(name, "/...") {
 			recursive = true
 			name = name[:len(name)-4]
 		}
 
-		stat, err := os.Stat(name)
+		statFn := os.Stat
+		if preserveLinks {
+			statFn = os.Lstat
+		}
+
+		stat, err := statFn(name)
 		if err != nil {
 			errChan <- errors.Wrap(err, "file info: "+name)
 			return
 		}
 
-		if stat.IsDir() {
+		if stat.Mode()&os.ModeSymlink != 0 {
+			if f, err := prepareLink(name, stat); err == nil {
+				fileChan <- f
+			} else {
+				errChan <- err
+			}
+		} else if stat.IsDir() {
 			if verbose {
 				if recursive {
 					log.Printf("walking directory '%s' recursively\n", name)
@@ -219,10 +460,33 @@ func processFile(name string, errChan chan<- error) <-chan file {
 						return filepath.SkipDir
 					}
 
+					fileChan <- prepareDir(path, stat)
+
 					return nil
 				}
 
-				if f, err := prepareFile(path, stat); err == nil {
+				if stat.Mode()&os.ModeSymlink != 0 {
+					if preserveLinks {
+						if f, err := prepareLink(path, stat); err == nil {
+							fileChan <- f
+						} else {
+							errChan <- err
+						}
+
+						return nil
+					}
+
+					// Walk doesn't follow links, so only the ones to
+					// files are read through
+					if stat, err = os.Stat(path); err != nil || stat.IsDir() {
+						if verbose {
+							log.Printf("skipping symlink %s\n", path)
+						}
+						return nil
+					}
+				}
+
+				if f, err := prepareFile(path, stat, override); err == nil {
 					fileChan <- f
 				} else {
 					if verbose {
@@ -234,7 +498,7 @@ func processFile(name string, errChan chan<- error) <-chan file {
 				return nil
 			})
 		} else {
-			if f, err := prepareFile(name, stat); err == nil {
+			if f, err := prepareFile(name, stat, override); err == nil {
 				fileChan <- f
 			} else {
 				errChan <- err
@@ -245,18 +509,159 @@ func processFile(name string, errChan chan<- error) <-chan file {
 	return fileChan
 }
 
-func prepareFile(name string, stat os.FileInfo) (file, error) {
+func prepareFile(name string, stat os.FileInfo, ctype string) (file, error) {
 	if verbose {
 		log.Printf("preparing file '%s'\n", name)
 	}
-	if b, err := ioutil.ReadFile(name); err == nil {
-		return file{
-			name, fmt.Sprintf("%q", b), stat.Size(),
-			uint32(stat.Mode()), stat.ModTime().Unix(),
-		}, nil
-	} else {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
 		return file{}, errors.Wrap(err, "reading file "+name)
 	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(b))
+	ctype = detectContentType(name, b, ctype)
+
+	var compressed bool
+	if compress {
+		if c, err := gzipData(b); err != nil {
+			return file{}, errors.Wrap(err, "compressing file "+name)
+		} else if len(c) < len(b) {
+			b, compressed = c, true
+		} else if verbose {
+			log.Printf("compressing file '%s' doesn't reduce its size\n", name)
+		}
+	}
+
+	f := file{
+		Name: name, Data: fmt.Sprintf("%q", b), Size: stat.Size(),
+		Mode: uint32(stat.Mode()), ModTime: stat.ModTime().Unix(),
+		Compressed: compressed, Hash: hash, ContentType: ctype, raw: b,
+	}
+
+	if fingerprint {
+		f.Fingerprint = fingerprintName(name, hash)
+	}
+
+	return f, nil
+}
+
+// detectContentType returns the MIME type of the named file: the given
+// override, the type of the first -content-type pattern matching the file's
+// base name, the type associated with its extension, or the one sniffed from
+// its data, in that order.
+func detectContentType(name string, data []byte, override string) string {
+	if override != "" {
+		return override
+	}
+
+	for _, ct := range contentTypes {
+		if ok, _ := filepath.Match(ct.pattern, filepath.Base(name)); ok {
+			return ct.ctype
+		}
+	}
+
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype
+	}
+
+	return http.DetectContentType(data)
+}
+
+// fingerprintName inserts the first 8 characters of the hash before the
+// extension of the file name, e.g. app.js -> app.3f9a1c2b.js.
+func fingerprintName(name, hash string) string {
+	ext := filepath.Ext(name)
+	return name[:len(name)-len(ext)] + "." + hash[:8] + ext
+}
+
+func gzipData(b []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// contentTypeFlag collects PATTERN=TYPE content type overrides.
+type contentTypeFlag []contentTypeOverride
+
+type contentTypeOverride struct {
+	pattern string
+	ctype   string
+}
+
+func (f *contentTypeFlag) String() string {
+	overrides := make([]string, 0, len(*f))
+	for _, ct := range *f {
+		overrides = append(overrides, ct.pattern+"="+ct.ctype)
+	}
+
+	return strings.Join(overrides, ",")
+}
+
+func (f *contentTypeFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i == -1 {
+		return errors.New("expected PATTERN=TYPE, got " + value)
+	}
+
+	pattern, ctype := value[:i], value[i+1:]
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return errors.Wrap(err, "invalid pattern "+pattern)
+	}
+
+	*f = append(*f, contentTypeOverride{pattern, ctype})
+
+	return nil
+}
+
+func prepareDir(name string, stat os.FileInfo) file {
+	if verbose {
+		log.Printf("preparing directory '%s'\n", name)
+	}
+
+	return file{
+		Name: name, Mode: uint32(stat.Mode()),
+		ModTime: stat.ModTime().Unix(), Dir: true,
+	}
+}
+
+// prepareLink records the target of the named symbolic link. Absolute targets
+// are made relative to the link, since the filesystem resolves them from its
+// root.
+func prepareLink(name string, stat os.FileInfo) (file, error) {
+	if verbose {
+		log.Printf("preparing symlink '%s'\n", name)
+	}
+
+	target, err := os.Readlink(name)
+	if err != nil {
+		return file{}, errors.Wrap(err, "reading symlink "+name)
+	}
+
+	if filepath.IsAbs(target) {
+		if dir, err := filepath.Abs(filepath.Dir(name)); err == nil {
+			if rel, err := filepath.Rel(dir, target); err == nil {
+				target = rel
+			}
+		}
+	}
+
+	return file{
+		Name: name, Link: filepath.ToSlash(target), Mode: uint32(stat.Mode()),
+		ModTime: stat.ModTime().Unix(),
+	}, nil
 }
 
 func init() {
@@ -264,6 +669,7 @@ func init() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\t%s [flags] files...\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n\t\tAll arguments are expected to be files\n\t\t  or directories to be added to the output.\n\t\t  A directory suffixed by '...' will be added\n\t\t  recursively.\n\n")
+		fmt.Fprintf(os.Stderr, "\t\tLines of an input file may end with\n\t\t  'content-type=TYPE' to set the content type\n\t\t  of the files they add.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
@@ -273,7 +679,17 @@ func init() {
 	flag.StringVar(&functionName, "function-name", "NewFileSystem", "name of the init function")
 	flag.StringVar(&packageName, "package-name", "main", "package name of the generated file")
 	flag.StringVar(&buildTags, "build-tags", "", "build tags for the generated file")
+	flag.BoolVar(&compress, "compress", false, "store the file data gzip compressed, when it reduces its size")
+	flag.BoolVar(&fingerprint, "fingerprint", false, "also add every file under a name containing a hash of its content,\n\tand record the mapping in the filesystem's manifest")
+	flag.BoolVar(&packed, "pack", false, "store all file data in a single pack, loaded with filesystem.LoadPack,\n\tinstead of adding every file with a separate call")
+	flag.StringVar(&packFile, "pack-file", "", "write a standalone pack to the given file instead of generating Go source.\n\tIt may be opened with filesystem.OpenPack, or appended to an executable\n\tand opened with filesystem.OpenSelf")
+	flag.StringVar(&devOutput, "dev-output", "", "also write a file, selected with the -dev-tag build tag, whose function\n\treads the files from their source directories instead of embedding them.\n\tThe main output is then excluded by the tag")
+	flag.StringVar(&devTag, "dev-tag", "dev", "build tag that selects the -dev-output file")
+	flag.BoolVar(&seal, "seal", false, "seal the created filesystem, which makes it immutable and lock-free to read")
+	flag.BoolVar(&preserveLinks, "preserve-symlinks", false, "add symbolic links as links, instead of adding the files they point to")
+	flag.Var(&contentTypes, "content-type", "PATTERN=TYPE content type for files whose base name matches the pattern.\n\tMay be repeated")
 	flag.BoolVar(&fatal, "fatal-errors", false, "treat non-fatal errors as fatal")
-	flag.BoolVar(&fallback, "fallback", false, "create an http.FileSystem that falls back to os.Open")
+	flag.BoolVar(&fallback, "fallback", false, "create an http.FileSystem that falls back to the operating system")
+	flag.StringVar(&fallbackDir, "fallback-dir", "", "create an http.FileSystem that falls back to the given directory")
 	flag.BoolVar(&verbose, "verbose", false, "output ")
 }
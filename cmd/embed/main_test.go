@@ -2,16 +2,23 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"go/ast"
 	"go/importer"
 	"go/parser"
 	"go/token"
 	"go/types"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/urandom/embed/filesystem"
 )
 
 type call struct {
@@ -28,9 +35,10 @@ func TestWrite(t *testing.T) {
 		header header
 		files  []string
 		calls  []call
+		dirs   []call
 	}{
 		{
-			header{"test", "Test", "", false},
+			header{"test", "Test", "", false, "", true},
 			[]string{"testdata/..."},
 			[]call{
 				{"\"testdata/1\"", "11", "420", "\"1234567890\\n\""},
@@ -38,17 +46,21 @@ func TestWrite(t *testing.T) {
 				{"\"testdata/foo.go\"", "65", "260", "\"package main\\n\\nimport \\\"fmt\\\"\\n\\nfunc main() {\\n\\tfmt.Println(\\\"test\\\")\\n}\\n\""},
 				{"\"testdata/vmlinuz\"", "20", "267", "\"MZ\\xea\\a\\x00\\xc0\\a\\x8cȎ؎\\xc0\\x8e\\xd01\\xe4\\xfb\\xfc\\xbe\""},
 			},
+			[]call{
+				{"\"testdata\"", "", "2147484141", ""},
+			},
 		},
 		{
-			header{"test2", "Test2", "some,tag", true},
+			header{"test2", "Test2", "some,tag", true, "", true},
 			[]string{"testdata/1", "testdata/vmlinuz"},
 			[]call{
 				{"\"testdata/1\"", "11", "420", "\"1234567890\\n\""},
 				{"\"testdata/vmlinuz\"", "20", "267", "\"MZ\\xea\\a\\x00\\xc0\\a\\x8cȎ؎\\xc0\\x8e\\xd01\\xe4\\xfb\\xfc\\xbe\""},
 			},
+			nil,
 		},
 		{
-			header{"test", "Test", "", false},
+			header{"test", "Test", "", false, "", false},
 			[]string{"testdata"},
 			[]call{
 				{"\"testdata/1\"", "11", "420", "\"1234567890\\n\""},
@@ -56,11 +68,15 @@ func TestWrite(t *testing.T) {
 				{"\"testdata/foo.go\"", "65", "260", "\"package main\\n\\nimport \\\"fmt\\\"\\n\\nfunc main() {\\n\\tfmt.Println(\\\"test\\\")\\n}\\n\""},
 				{"\"testdata/vmlinuz\"", "20", "267", "\"MZ\\xea\\a\\x00\\xc0\\a\\x8cȎ؎\\xc0\\x8e\\xd01\\xe4\\xfb\\xfc\\xbe\""},
 			},
+			[]call{
+				{"\"testdata\"", "", "2147484141", ""},
+			},
 		},
 		{
-			header{"test2", "Test2", "", true},
+			header{"test2", "Test2", "", true, "/srv/assets", true},
 			[]string{},
 			[]call{},
+			nil,
 		},
 	}
 
@@ -70,17 +86,7 @@ func TestWrite(t *testing.T) {
 
 			writeData(buf, tc.header, tc.files, false, false)
 
-			fset := token.NewFileSet()
-			f, err := parser.ParseFile(fset, "file_data.go", buf.Bytes(), 0)
-			if err != nil {
-				t.Fatalf("parsing expr: %+v", err)
-			}
-
-			conf := types.Config{Importer: importer.Default()}
-			_, err = conf.Check("hello", fset, []*ast.File{f}, nil)
-			if err != nil {
-				t.Fatalf("checking: %+v", err)
-			}
+			f := checkGenerated(t, buf.Bytes())
 
 			if tc.header.Tags == "" {
 				if strings.Contains(buf.String(), "// +build") {
@@ -92,6 +98,16 @@ func TestWrite(t *testing.T) {
 				}
 			}
 
+			if tc.header.FallbackDir != "" {
+				if !strings.Contains(buf.String(), fmt.Sprintf("fs.FallbackFS = filesystem.Dir(%q)", tc.header.FallbackDir)) {
+					t.Fatalf("A fallback directory was expected")
+				}
+			}
+
+			if strings.Contains(buf.String(), "fs.Seal()") != tc.header.Seal {
+				t.Fatalf("expected sealing to be %v", tc.header.Seal)
+			}
+
 			if f.Name.Name != tc.header.Pkg {
 				t.Fatalf("expected package name %s, got %s", tc.header.Pkg, f.Name.Name)
 			}
@@ -104,7 +120,7 @@ func TestWrite(t *testing.T) {
 				t.Fatalf("Expected a func declaration")
 			}
 
-			addCalls := 0
+			addCalls, addDirCalls := 0, 0
 			ast.Inspect(f, func(n ast.Node) bool {
 				if callExpr, ok := n.(*ast.CallExpr); ok {
 					selX, ok := callExpr.Fun.(*ast.SelectorExpr)
@@ -113,6 +129,30 @@ func TestWrite(t *testing.T) {
 					}
 
 					ident, ok := selX.X.(*ast.Ident)
+					if ok && ident.Name == "fs" && selX.Sel.Name == "AddDir" {
+						call := tc.dirs[addDirCalls]
+						addDirCalls++
+
+						if len(callExpr.Args) != 3 {
+							t.Fatalf("expected 3 arguments, got %d", len(callExpr.Args))
+						}
+
+						if first, ok := callExpr.Args[0].(*ast.BasicLit); !ok || first.Value != call.name {
+							t.Fatalf("Expected %s, got %#v", call.name, callExpr.Args[0])
+						}
+
+						second, ok := callExpr.Args[1].(*ast.CallExpr)
+						if !ok || len(second.Args) != 1 {
+							t.Fatalf("Expected a call expression with 1 argument")
+						}
+
+						if lit, ok := second.Args[0].(*ast.BasicLit); !ok || lit.Value != call.mode {
+							t.Fatalf("Expected %s for %s, got %#v", call.mode, call.name, second.Args[0])
+						}
+
+						return true
+					}
+
 					if !ok || ident.Name != "fs" || selX.Sel.Name != "Add" {
 						return true
 					}
@@ -120,8 +160,8 @@ func TestWrite(t *testing.T) {
 					call := tc.calls[addCalls]
 					addCalls++
 
-					if len(callExpr.Args) != 5 {
-						t.Fatalf("expected 5 arguments, got %d", len(callExpr.Args))
+					if len(callExpr.Args) != 7 {
+						t.Fatalf("expected 7 arguments, got %d", len(callExpr.Args))
 					}
 
 					first, ok := callExpr.Args[0].(*ast.BasicLit)
@@ -206,6 +246,17 @@ func TestWrite(t *testing.T) {
 					if fifth.Value != call.data {
 						t.Fatalf("Expected %s, got %s", call.data, fifth.Value)
 					}
+
+					sixth, ok := callExpr.Args[5].(*ast.CallExpr)
+					if !ok || len(sixth.Args) != 1 {
+						t.Fatalf("Expected a call expression with 1 argument")
+					}
+
+					data, _ := strconv.Unquote(call.data)
+					hash := fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256([]byte(data))))
+					if lit, ok := sixth.Args[0].(*ast.BasicLit); !ok || lit.Value != hash {
+						t.Fatalf("Expected hash %s for %s, got %#v", hash, call.name, sixth.Args[0])
+					}
 				}
 
 				return true
@@ -214,11 +265,77 @@ func TestWrite(t *testing.T) {
 			if addCalls != len(tc.calls) {
 				t.Fatalf("expected %d fs.Add calls, got %d", len(tc.calls), addCalls)
 			}
+
+			if addDirCalls != len(tc.dirs) {
+				t.Fatalf("expected %d fs.AddDir calls, got %d", len(tc.dirs), addDirCalls)
+			}
 		})
 	}
 
 }
 
+func TestCompress(t *testing.T) {
+	data := strings.Repeat("compressible ", 100)
+	name := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	compress = true
+	defer func() { compress = false }()
+
+	buf := &buffer{}
+	writeData(buf, header{"test", "Test", "", false, "", true}, []string{name, "testdata/1"}, false, false)
+
+	f := checkGenerated(t, buf.Bytes())
+
+	var calls []*ast.CallExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if callExpr, ok := n.(*ast.CallExpr); ok {
+			if selX, ok := callExpr.Fun.(*ast.SelectorExpr); ok && selX.Sel.Name == "Add" {
+				calls = append(calls, callExpr)
+			}
+		}
+
+		return true
+	})
+
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 fs.Add calls, got %d", len(calls))
+	}
+
+	if len(calls[0].Args) != 8 {
+		t.Fatalf("expected 8 arguments, got %d", len(calls[0].Args))
+	}
+
+	if size := calls[0].Args[1].(*ast.BasicLit).Value; size != fmt.Sprint(len(data)) {
+		t.Fatalf("expected the uncompressed size %d, got %s", len(data), size)
+	}
+
+	if opt, ok := calls[0].Args[7].(*ast.CallExpr); !ok || opt.Fun.(*ast.SelectorExpr).Sel.Name != "Gzipped" {
+		t.Fatalf("expected a filesystem.Gzipped option")
+	}
+
+	lit, err := strconv.Unquote(calls[0].Args[4].(*ast.BasicLit).Value)
+	if err != nil {
+		t.Fatalf("unquoting data: %+v", err)
+	}
+
+	r, err := gzip.NewReader(strings.NewReader(lit))
+	if err != nil {
+		t.Fatalf("decompressing data: %+v", err)
+	}
+
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != data {
+		t.Fatalf("expected the original data, got %+v", err)
+	}
+
+	// Compressing the small file would only increase its size
+	if len(calls[1].Args) != 7 {
+		t.Fatalf("expected 7 arguments, got %d", len(calls[1].Args))
+	}
+}
+
 type buffer struct {
 	bytes.Buffer
 }
@@ -228,6 +345,9 @@ func (cb *buffer) Close() error {
 }
 
 func init() {
+	if err := os.Chmod("testdata", 0755); err != nil {
+		log.Fatal(err)
+	}
 	if err := os.Chmod("testdata/1", 0644); err != nil {
 		log.Fatal(err)
 	}
@@ -241,3 +361,400 @@ func init() {
 		log.Fatal(err)
 	}
 }
+
+func TestFingerprint(t *testing.T) {
+	names := []struct {
+		name, fingerprinted string
+	}{
+		{"app.js", "app.0123abcd.js"},
+		{"static/app.min.css", "static/app.min.0123abcd.css"},
+		{"LICENSE", "LICENSE.0123abcd"},
+		{"v1.2/README", "v1.2/README.0123abcd"},
+	}
+
+	for _, tc := range names {
+		if name := fingerprintName(tc.name, "0123abcdef"); name != tc.fingerprinted {
+			t.Fatalf("expected %s, got %s", tc.fingerprinted, name)
+		}
+	}
+
+	fingerprint = true
+	defer func() { fingerprint = false }()
+
+	buf := &buffer{}
+	writeData(buf, header{"test", "Test", "", false, "", true}, []string{"testdata/1", "testdata/foo.go"}, false, false)
+
+	f := checkGenerated(t, buf.Bytes())
+
+	expected := map[string]string{}
+	for _, name := range []string{"testdata/1", "testdata/foo.go"} {
+		b, _ := ioutil.ReadFile(name)
+		expected[fmt.Sprintf("%q", name)] = fmt.Sprintf("%q", fingerprintName(name, fmt.Sprintf("%x", sha256.Sum256(b))))
+	}
+
+	manifest := map[string]string{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if kv, ok := n.(*ast.KeyValueExpr); ok {
+			manifest[kv.Key.(*ast.BasicLit).Value] = kv.Value.(*ast.BasicLit).Value
+		}
+
+		return true
+	})
+
+	if len(manifest) != len(expected) {
+		t.Fatalf("expected manifest %v, got %v", expected, manifest)
+	}
+
+	for name, alias := range expected {
+		if manifest[name] != alias {
+			t.Fatalf("expected alias %s for %s, got %s", alias, name, manifest[name])
+		}
+	}
+
+	if !strings.Contains(buf.String(), "fs.AddFingerprint(name, alias)") {
+		t.Fatalf("expected the manifest to be added to the filesystem")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(in, []byte("testdata/1\ntestdata/2 content-type=text/x-custom # comment\ntestdata/vmlinuz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := contentTypes.Set("vm*=application/x-msdownload"); err != nil {
+		t.Fatalf("setting content type: %+v", err)
+	}
+
+	if err := contentTypes.Set("vm*"); err == nil {
+		t.Fatalf("expected an error for a missing type")
+	}
+
+	defer func() {
+		contentTypes = nil
+		inputTypes = map[string]string{}
+	}()
+
+	names := processInput(in)
+	if len(names) != 3 || names[1] != "testdata/2" {
+		t.Fatalf("unexpected input names %v", names)
+	}
+
+	buf := &buffer{}
+	writeData(buf, header{"test", "Test", "", false, "", true}, names, false, false)
+
+	f := checkGenerated(t, buf.Bytes())
+
+	expected := map[string]string{
+		`"testdata/1"`:       `"text/plain; charset=utf-8"`,
+		`"testdata/2"`:       `"text/x-custom"`,
+		`"testdata/vmlinuz"`: `"application/x-msdownload"`,
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		if callExpr, ok := n.(*ast.CallExpr); ok {
+			if selX, ok := callExpr.Fun.(*ast.SelectorExpr); ok && selX.Sel.Name == "Add" {
+				name := callExpr.Args[0].(*ast.BasicLit).Value
+				opt := callExpr.Args[6].(*ast.CallExpr)
+
+				if opt.Fun.(*ast.SelectorExpr).Sel.Name != "ContentType" {
+					t.Fatalf("expected a filesystem.ContentType option")
+				}
+
+				if ctype := opt.Args[0].(*ast.BasicLit).Value; ctype != expected[name] {
+					t.Fatalf("expected content type %s for %s, got %s", expected[name], name, ctype)
+				}
+
+				delete(expected, name)
+			}
+		}
+
+		return true
+	})
+
+	if len(expected) != 0 {
+		t.Fatalf("missing fs.Add calls for %v", expected)
+	}
+}
+
+func TestPack(t *testing.T) {
+	fingerprint = true
+	defer func() { fingerprint = false }()
+
+	buf := &buffer{}
+	writePack(buf, header{"test", "Test", "some,tag", false, "static", true}, []string{"testdata/..."}, false, false)
+
+	f := checkGenerated(t, buf.Bytes())
+
+	if strings.Contains(buf.String(), "fs.Add(") || !strings.Contains(buf.String(), "fs.Seal()") {
+		t.Fatalf("expected no fs.Add calls, and a sealed filesystem")
+	}
+
+	if !strings.HasPrefix(buf.String(), "// +build some,tag") || !strings.Contains(buf.String(), `filesystem.Dir("static")`) {
+		t.Fatalf("expected build tags and fallback, got %s", buf.String())
+	}
+
+	var data string
+	ast.Inspect(f, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.ValueSpec); ok && spec.Names[0].Name == "packTest" {
+			data, _ = strconv.Unquote(spec.Values[0].(*ast.BasicLit).Value)
+		}
+
+		return true
+	})
+
+	fs, err := filesystem.LoadPack(data)
+	if err != nil {
+		t.Fatalf("loading pack: %+v", err)
+	}
+
+	b, _ := ioutil.ReadFile("testdata/foo.go")
+	hash := fmt.Sprintf("%x", sha256.Sum256(b))
+
+	for _, name := range []string{"testdata/foo.go", fingerprintName("testdata/foo.go", hash)} {
+		file, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("opening %s: %+v", name, err)
+		}
+
+		if data, _ := ioutil.ReadAll(file); !bytes.Equal(data, b) {
+			t.Fatalf("expected data %q, got %q", b, data)
+		}
+	}
+
+	if h, _ := fs.Hash("testdata/foo.go"); h != hash {
+		t.Fatalf("expected hash %s, got %s", hash, h)
+	}
+
+	if d, err := fs.Open("testdata"); err != nil {
+		t.Fatalf("opening dir: %+v", err)
+	} else if stat, _ := d.Stat(); stat.Mode() != os.FileMode(2147484141) {
+		t.Fatalf("expected dir mode %v, got %v", os.FileMode(2147484141), stat.Mode())
+	}
+}
+
+func TestPackFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "assets.pack")
+	writePackFile(name, []string{"testdata/..."}, false, false)
+
+	fs, err := filesystem.OpenPack(name)
+	if err != nil {
+		t.Fatalf("opening pack: %+v", err)
+	}
+	defer fs.Close()
+
+	f, err := fs.Open("testdata/2")
+	if err != nil {
+		t.Fatalf("opening file: %+v", err)
+	}
+
+	if b, _ := ioutil.ReadAll(f); string(b) != "0987654321\n" {
+		t.Fatalf("expected data %q, got %q", "0987654321\n", b)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "light.css"), []byte("body {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "themes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for link, target := range map[string]string{
+		"dark.css": "../light.css",
+		"abs.css":  filepath.Join(dir, "light.css"),
+		"parent":   "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, "themes", link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := func(buf *buffer) map[string]string {
+		f := checkGenerated(t, buf.Bytes())
+
+		links := map[string]string{}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if callExpr, ok := n.(*ast.CallExpr); ok {
+				if selX, ok := callExpr.Fun.(*ast.SelectorExpr); ok && selX.Sel.Name == "AddSymlink" {
+					name, _ := strconv.Unquote(callExpr.Args[0].(*ast.BasicLit).Value)
+					target, _ := strconv.Unquote(callExpr.Args[1].(*ast.BasicLit).Value)
+					links[filepath.Base(name)] = target
+				}
+			}
+
+			return true
+		})
+
+		return links
+	}
+
+	buf := &buffer{}
+	writeData(buf, header{"test", "Test", "", false, "", true}, []string{dir + "/..."}, false, false)
+
+	if l := links(buf); len(l) != 0 {
+		t.Fatalf("expected no symlinks, got %v", l)
+	}
+
+	// The links to files are read through, while the one to a directory is skipped
+	if strings.Count(buf.String(), `"body {}\n"`) != 3 || strings.Contains(buf.String(), "parent") {
+		t.Fatalf("expected the linked file data three times, got %s", buf.String())
+	}
+
+	preserveLinks = true
+	defer func() { preserveLinks = false }()
+
+	buf = &buffer{}
+	writeData(buf, header{"test", "Test", "", false, "", true}, []string{dir + "/..."}, false, false)
+
+	expected := map[string]string{"dark.css": "../light.css", "abs.css": "../light.css", "parent": ".."}
+	l := links(buf)
+	if len(l) != len(expected) {
+		t.Fatalf("expected symlinks %v, got %v", expected, l)
+	}
+
+	for name, target := range expected {
+		if l[name] != target {
+			t.Fatalf("expected target %s for %s, got %s", target, name, l[name])
+		}
+	}
+
+	if strings.Count(buf.String(), `"body {}\n"`) != 1 {
+		t.Fatalf("expected the linked file data once")
+	}
+}
+
+func TestDev(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file_data_dev.go")
+	writeDevFile(name, header{"test", "Test", "some,tag other", true, "static", true}, []string{"testdata/...", "main.go"})
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("reading dev file: %+v", err)
+	}
+
+	checkGenerated(t, b)
+
+	if !strings.HasPrefix(string(b), "// +build some,tag,dev other,dev") {
+		t.Fatalf("expected the dev build tag, got %s", b)
+	}
+
+	wd, _ := os.Getwd()
+	root, _ := filepath.Rel(filepath.Dir(name), wd)
+	for _, s := range []string{strconv.Quote(filepath.ToSlash(root)), `"testdata/..."`, `"main.go"`, `filesystem.Dir("static")`} {
+		if !strings.Contains(string(b), s) {
+			t.Fatalf("expected %s in the dev file, got %s", s, b)
+		}
+	}
+
+	cases := []struct {
+		tags, tag, expected string
+	}{
+		{"", "dev", "dev"},
+		{"", "!dev", "!dev"},
+		{"linux", "!dev", "linux,!dev"},
+		{"linux,386 darwin", "!dev", "linux,386,!dev darwin,!dev"},
+	}
+
+	for i, tc := range cases {
+		if c := constrain(tc.tags, tc.tag); c != tc.expected {
+			t.Fatalf("case %d: expected %q, got %q", i, tc.expected, c)
+		}
+	}
+}
+
+func TestDevNames(t *testing.T) {
+	wd, _ := os.Getwd()
+	inputs := []string{filepath.Join(wd, "testdata") + "/...", "../embed/testdata/1"}
+
+	name := filepath.Join(t.TempDir(), "file_data_dev.go")
+	writeDevFile(name, header{"test", "Test", "", false, "", false}, inputs)
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("reading dev file: %+v", err)
+	}
+
+	// The source is created from the generated root and names
+	var source filesystem.Source
+	ast.Inspect(checkGenerated(t, b), func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "FromSlash" {
+				rel, _ := strconv.Unquote(n.Args[0].(*ast.BasicLit).Value)
+				source.Root = filesystem.Dir(filepath.Join(filepath.Dir(name), filepath.FromSlash(rel)))
+			}
+		case *ast.KeyValueExpr:
+			if key, ok := n.Key.(*ast.Ident); ok && key.Name == "Names" {
+				for _, elt := range n.Value.(*ast.CompositeLit).Elts {
+					name, _ := strconv.Unquote(elt.(*ast.BasicLit).Value)
+					source.Names = append(source.Names, name)
+				}
+			}
+		}
+
+		return true
+	})
+
+	fs, err := buildFileSystem(inputs, true, false)
+	if err != nil {
+		t.Fatalf("building filesystem: %+v", err)
+	}
+
+	var files int
+	err = fs.Walk(".", func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		files++
+
+		f, err := source.Open(name)
+		if err != nil {
+			t.Fatalf("opening %s from the source: %+v", name, err)
+		}
+		defer f.Close()
+
+		expected, _ := fs.Open(name)
+		defer expected.Close()
+
+		b, _ := ioutil.ReadAll(f)
+		if e, _ := ioutil.ReadAll(expected); string(b) != string(e) {
+			t.Fatalf("expected data %q for %s, got %q", e, name, b)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking: %+v", err)
+	}
+
+	if files < 2 {
+		t.Fatalf("expected files from both inputs, got %d", files)
+	}
+
+	if _, err := source.Open("../embed/testdata/2"); !os.IsNotExist(err) {
+		t.Fatalf("expected ErrNotExist for a file that isn't named, got %+v", err)
+	}
+}
+
+// checkGenerated parses and type checks the generated source.
+func checkGenerated(t *testing.T, src []byte) *ast.File {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "file_data.go", src, 0)
+	if err != nil {
+		t.Fatalf("parsing expr: %+v", err)
+	}
+
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("hello", fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("checking: %+v", err)
+	}
+
+	return f
+}
@@ -3,25 +3,56 @@ package main
 import "text/template"
 
 type header struct {
-	Pkg      string
-	Function string
-	Tags     string
-	Fallback bool
+	Pkg         string
+	Function    string
+	Tags        string
+	Fallback    bool
+	FallbackDir string
+	Seal        bool
 }
 
 type file struct {
-	Name    string
-	Data    string
-	Size    int64
-	Mode    uint32
-	ModTime int64
+	Name        string
+	Data        string
+	Size        int64
+	Mode        uint32
+	ModTime     int64
+	Dir         bool
+	Link        string
+	Compressed  bool
+	Hash        string
+	ContentType string
+	Fingerprint string
+
+	// raw holds the, possibly compressed, file data.
+	raw []byte
+}
+
+type footer struct {
+	Seal     bool
+	Manifest []file
+}
+
+type pack struct {
+	header
+	Data string
+}
+
+type dev struct {
+	header
+	Root  string
+	Names []string
 }
 
 var (
 	headerTmpl      = template.Must(template.New("gen-header").Parse(headerData))
 	emptyHeaderTmpl = template.Must(template.New("gen-empty-header").Parse(emptyHeaderData))
 	fileTmpl        = template.Must(template.New("gen-file").Parse(fileData))
+	dirTmpl         = template.Must(template.New("gen-dir").Parse(dirData))
+	linkTmpl        = template.Must(template.New("gen-link").Parse(linkData))
 	footerTmpl      = template.Must(template.New("gen-footer").Parse(footerData))
+	packTmpl        = template.Must(template.New("gen-pack").Parse(packData))
+	devTmpl         = template.Must(template.New("gen-dev").Parse(devData))
 )
 
 const (
@@ -46,7 +77,9 @@ import (
 // {{ .Function }} creates a new filesystem with pre-filled binary data.
 func {{ .Function }}() (http.FileSystem, error) {
 	fs := filesystem.New()
-{{ if .Fallback }}
+{{ if .FallbackDir }}
+	fs.FallbackFS = filesystem.Dir({{ printf "%q" .FallbackDir }})
+{{ else if .Fallback }}
 	fs.Fallback = true
 {{ end -}}
 `
@@ -68,18 +101,127 @@ import (
 // {{ .Function }} creates a new empty filesystem.
 func {{ .Function }}() (http.FileSystem, error) {
 	fs := filesystem.New()
-{{ if .Fallback }}
+{{ if .FallbackDir }}
+	fs.FallbackFS = filesystem.Dir({{ printf "%q" .FallbackDir }})
+{{ else if .Fallback }}
 	fs.Fallback = true
 {{ end -}}
 `
 
 	fileData = `
-	if err := fs.Add("{{ .Name }}", {{ .Size }}, os.FileMode({{ .Mode }}), time.Unix({{ .ModTime }}, 0), {{ .Data }}); err != nil {
+	if err := fs.Add("{{ .Name }}", {{ .Size }}, os.FileMode({{ .Mode }}), time.Unix({{ .ModTime }}, 0), {{ .Data }}, filesystem.SHA256("{{ .Hash }}"), filesystem.ContentType({{ printf "%q" .ContentType }}){{ if .Compressed }}, filesystem.Gzipped(){{ end }}); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("packing file {{ .Name }}"))
 	}
 `
 
+	dirData = `
+	if err := fs.AddDir("{{ .Name }}", os.FileMode({{ .Mode }}), time.Unix({{ .ModTime }}, 0)); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("packing directory {{ .Name }}"))
+	}
+`
+
+	linkData = `
+	if err := fs.AddSymlink("{{ .Name }}", {{ printf "%q" .Link }}, time.Unix({{ .ModTime }}, 0)); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("packing symlink {{ .Name }}"))
+	}
+`
+
 	footerData = `
+{{- if .Manifest }}
+	manifest := map[string]string{
+{{- range .Manifest }}
+		"{{ .Name }}": "{{ .Fingerprint }}",
+{{- end }}
+	}
+
+	for name, alias := range manifest {
+		if err := fs.AddFingerprint(name, alias); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("fingerprinting file %s", name))
+		}
+	}
+{{ end }}
+{{- if .Seal }}
+	fs.Seal()
+{{ end }}
+	return fs, nil
+}
+`
+
+	packData = `
+{{- if .Tags }}// +build {{ .Tags }}
+{{- end }}
+
+// DO NOT EDIT ** This file was generated with github.com/urandom/embed ** DO NOT EDIT //
+
+package {{ .Pkg }}
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/embed/filesystem"
+)
+
+// {{ .Function }} creates a new filesystem with pre-filled binary data.
+func {{ .Function }}() (http.FileSystem, error) {
+	fs, err := filesystem.LoadPack(pack{{ .Function }})
+	if err != nil {
+		return nil, errors.Wrap(err, "loading pack")
+	}
+{{ if .FallbackDir }}
+	fs.FallbackFS = filesystem.Dir({{ printf "%q" .FallbackDir }})
+{{ else if .Fallback }}
+	fs.Fallback = true
+{{ end }}
+{{- if .Seal }}
+	fs.Seal()
+{{ end }}
+	return fs, nil
+}
+
+const pack{{ .Function }} = {{ .Data }}
+`
+
+	devData = `
+{{- if .Tags }}// +build {{ .Tags }}
+{{- end }}
+
+// DO NOT EDIT ** This file was generated with github.com/urandom/embed ** DO NOT EDIT //
+
+package {{ .Pkg }}
+
+import (
+	"net/http"
+	"path/filepath"
+	"runtime"
+
+	"github.com/urandom/embed/filesystem"
+)
+
+// {{ .Function }} creates a new filesystem that reads the files from their
+// source directories, instead of embedding them.
+func {{ .Function }}() (http.FileSystem, error) {
+	// The source directory is relative to the directory of this file
+	_, file, _, _ := runtime.Caller(0)
+	root := filepath.Join(filepath.Dir(file), filepath.FromSlash({{ printf "%q" .Root }}))
+
+	fs := filesystem.New()
+	fs.FallbackFS = filesystem.Source{
+		Root: filesystem.Dir(root),
+		Names: []string{
+{{- range .Names }}
+			{{ printf "%q" . }},
+{{- end }}
+		},
+{{- if .FallbackDir }}
+		Fallback: filesystem.Dir({{ printf "%q" .FallbackDir }}),
+{{- else if .Fallback }}
+		Fallback: filesystem.Dir("."),
+{{- end }}
+	}
+{{ if .Seal }}
+	fs.Seal()
+{{ end }}
 	return fs, nil
 }
 `
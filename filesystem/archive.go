@@ -0,0 +1,250 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// NewFromZip creates a FileSystem with the contents of the zip archive read
+// from r, which has the given size. Only the archive's directory is read
+// upfront: the data of its files is decompressed from r on demand, which
+// therefore has to remain readable for as long as the filesystem is used.
+//
+// Directories, regular files and symbolic links are added with their modes
+// and modification times, while other kinds of entries are skipped.
+func NewFromZip(r io.ReaderAt, size int64) (*FileSystem, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := New()
+	for _, f := range zr.File {
+		name, err := archiveName(f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = fs.AddDir(name, mode, f.Modified)
+		case mode.IsRegular():
+			err = fs.Add(name, int64(f.UncompressedSize64), mode, f.Modified, "", stream(f.Open))
+		case mode&os.ModeSymlink != 0:
+			var target string
+			if target, err = readZipFile(f); err == nil {
+				err = fs.AddSymlink(name, target, f.Modified)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// NewFromTar creates a FileSystem with the contents of the tar archive read
+// from r. Since tar archives can't be read at random, the data of all files
+// is held in memory.
+//
+// Directories, regular files and symbolic links are added with their modes
+// and modification times, while other kinds of entries are skipped.
+func NewFromTar(r io.Reader) (*FileSystem, error) {
+	tr := tar.NewReader(r)
+
+	fs := New()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		name, err := archiveName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch {
+		case mode.IsDir():
+			err = fs.AddDir(name, mode, hdr.ModTime)
+		case mode.IsRegular():
+			var b []byte
+			if b, err = io.ReadAll(tr); err == nil {
+				err = fs.Add(name, int64(len(b)), mode, hdr.ModTime, string(b))
+			}
+		case hdr.Typeflag == tar.TypeSymlink:
+			err = fs.AddSymlink(name, hdr.Linkname, hdr.ModTime)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// readZipFile returns the contents of the zip archive member.
+func readZipFile(f *zip.File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+
+	return string(b), err
+}
+
+// stream makes the data of an added file be read on demand from the reader
+// returned by open, instead of the data passed to Add.
+func stream(open func() (io.ReadCloser, error)) Option {
+	return func(n *node) {
+		n.stream = open
+	}
+}
+
+// archiveName cleans the name of an archive member, refusing ones that would
+// lead outside of the filesystem's root.
+func archiveName(name string) (string, error) {
+	p := clean(name)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", &os.PathError{Op: "add", Path: name, Err: os.ErrInvalid}
+	}
+
+	return p, nil
+}
+
+// WriteTar writes the contents of the filesystem to w as a tar archive.
+// Entries are written in lexical order, with their names, modes and
+// modification times preserved, so that the same filesystem always produces
+// the same archive. Files stored gzip compressed are written decompressed.
+// Files that are only available through a fallback are not included.
+func (fs *FileSystem) WriteTar(w io.Writer) error {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	tw := tar.NewWriter(w)
+
+	err := fs.walk(".", fs.root, func(name string, n *node) error {
+		link := n.stat.mode&os.ModeSymlink != 0
+
+		var target string
+		if link {
+			target = n.data
+		}
+
+		hdr, err := tar.FileInfoHeader(n.stat, target)
+		if err != nil {
+			return &os.PathError{Op: "archive", Path: name, Err: err}
+		}
+
+		hdr.Name = name
+		if n.stat.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if n.stat.IsDir() || link {
+			return nil
+		}
+
+		b, err := n.bytes(name)
+		if err != nil {
+			return &os.PathError{Op: "archive", Path: name, Err: err}
+		}
+
+		_, err = tw.Write(b)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// WriteZip writes the contents of the filesystem to w as a zip archive, in
+// the same manner as WriteTar.
+func (fs *FileSystem) WriteZip(w io.Writer) error {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	zw := zip.NewWriter(w)
+
+	err := fs.walk(".", fs.root, func(name string, n *node) error {
+		hdr, err := zip.FileInfoHeader(n.stat)
+		if err != nil {
+			return &os.PathError{Op: "archive", Path: name, Err: err}
+		}
+
+		hdr.Name = name
+		if n.stat.IsDir() {
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+		} else {
+			hdr.Method = zip.Deflate
+		}
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if n.stat.IsDir() {
+			return nil
+		}
+
+		b, err := n.bytes(name)
+		if err != nil {
+			return &os.PathError{Op: "archive", Path: name, Err: err}
+		}
+
+		_, err = fw.Write(b)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// walk calls fn for each descendant of the named directory node, in lexical
+// order, with directories visited before their contents. The names passed to
+// fn are relative to the root of the filesystem.
+func (fs *FileSystem) walk(name string, n *node, fn func(name string, n *node) error) error {
+	for _, c := range fs.children(name, n) {
+		p := path.Join(name, c.name)
+
+		if err := fn(p, c); err != nil {
+			return err
+		}
+
+		if c.stat.IsDir() {
+			if err := fs.walk(p, c, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package filesystem
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// An ExtractOption changes how Extract writes files to the operating system.
+type ExtractOption func(o *extractOptions)
+
+type extractOptions struct {
+	root      string
+	overwrite bool
+	skip      bool
+	unchanged bool
+	atomic    bool
+}
+
+// Subtree restricts Extract to the contents of the named directory, which are
+// written directly into the target directory.
+func Subtree(name string) ExtractOption {
+	return func(o *extractOptions) {
+		o.root = name
+	}
+}
+
+// Overwrite makes Extract replace files that already exist in the target
+// directory, instead of failing with an error satisfying os.IsExist.
+func Overwrite() ExtractOption {
+	return func(o *extractOptions) {
+		o.overwrite = true
+	}
+}
+
+// SkipExisting makes Extract leave files that already exist in the target
+// directory untouched.
+func SkipExisting() ExtractOption {
+	return func(o *extractOptions) {
+		o.skip = true
+	}
+}
+
+// SkipUnchanged makes Extract leave existing files untouched if they have the
+// same size and SHA-256 sum as the files in the filesystem. Existing files
+// that differ are handled according to the other options.
+func SkipUnchanged() ExtractOption {
+	return func(o *extractOptions) {
+		o.unchanged = true
+	}
+}
+
+// Atomic makes Extract write each file to a temporary file in the same
+// directory first, and rename it to its final name once complete, so that the
+// file is never observed partially written.
+func Atomic() ExtractOption {
+	return func(o *extractOptions) {
+		o.atomic = true
+	}
+}
+
+// Extract writes the contents of the filesystem into the directory dir of the
+// operating system, creating it if necessary. Files and directories are
+// written with their modes and modification times, while symbolic links are
+// recreated, with absolute targets made relative to the links. Files stored gzip
+// compressed are written decompressed, and files that are only available
+// through a fallback are not written.
+//
+// By default, Extract fails if a file already exists, which may be changed
+// with the Overwrite, SkipExisting and SkipUnchanged options. Existing
+// directories are reused.
+func (fs *FileSystem) Extract(dir string, options ...ExtractOption) error {
+	o := extractOptions{root: "."}
+	for _, opt := range options {
+		opt(&o)
+	}
+
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	root, rootName, ok := fs.resolve(clean(o.root), true)
+	if !ok {
+		return &os.PathError{Op: "extract", Path: o.root, Err: os.ErrNotExist}
+	}
+
+	if !root.stat.IsDir() {
+		return &os.PathError{Op: "extract", Path: o.root, Err: os.ErrInvalid}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var dirs []*node
+	var names []string
+
+	err := fs.walk(rootName, root, func(name string, n *node) error {
+		rel := name
+		if rootName != "." {
+			rel = name[len(rootName)+1:]
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+
+		if n.stat.IsDir() {
+			dirs, names = append(dirs, n), append(names, target)
+
+			// The directory has to be writable until its contents are in place
+			if err := os.Mkdir(target, 0700); err != nil && !isDir(target) {
+				return err
+			}
+
+			return nil
+		}
+
+		return o.extract(target, name, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Restoring the metadata of the deepest directories first, since writing
+	// into a directory changes its modification time
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restore(names[i], dirs[i].stat); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extract writes the node's data to the target file, unless the options
+// dictate otherwise.
+func (o extractOptions) extract(target, name string, n *node) error {
+	if existing, err := os.Lstat(target); err == nil {
+		switch {
+		case existing.IsDir():
+			return &os.PathError{Op: "extract", Path: target, Err: os.ErrExist}
+		case o.unchanged && unchanged(target, existing, name, n):
+			return nil
+		case o.skip:
+			return nil
+		case !o.overwrite:
+			return &os.PathError{Op: "extract", Path: target, Err: os.ErrExist}
+		case !o.atomic:
+			// Not writing through read-only files or symbolic links
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if n.stat.mode&os.ModeSymlink != 0 {
+		return o.symlink(target, name, n)
+	}
+
+	var f *os.File
+	var err error
+	if o.atomic {
+		f, err = os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	} else {
+		f, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, n.stat.mode.Perm())
+	}
+	if err != nil {
+		return err
+	}
+
+	r := n.open(name)
+	defer r.Close()
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = restore(f.Name(), n.stat)
+	}
+	if err == nil && o.atomic {
+		err = os.Rename(f.Name(), target)
+	}
+
+	if err != nil && o.atomic {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// symlink creates a symbolic link at the target path. Absolute link targets,
+// which refer to the root of the filesystem, are made relative to the link.
+func (o extractOptions) symlink(target, name string, n *node) error {
+	link := linkTarget(name, n.data)
+
+	if !o.atomic {
+		return os.Symlink(link, target)
+	}
+
+	// Reserving a unique name for the new link
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	if err := os.Symlink(link, f.Name()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), target); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// linkTarget returns the target of the named link as an OS path relative to
+// the link.
+func linkTarget(name, target string) string {
+	if !path.IsAbs(target) {
+		return filepath.FromSlash(target)
+	}
+
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(name)), filepath.FromSlash(clean(target)))
+	if err != nil {
+		return filepath.FromSlash(target)
+	}
+
+	return rel
+}
+
+// unchanged reports whether the existing file has the same size and content
+// as the node, or is a symbolic link with the same target.
+func unchanged(target string, existing os.FileInfo, name string, n *node) bool {
+	if n.stat.mode&os.ModeSymlink != 0 {
+		link, err := os.Readlink(target)
+		return err == nil && existing.Mode()&os.ModeSymlink != 0 && link == linkTarget(name, n.data)
+	}
+
+	if !existing.Mode().IsRegular() || existing.Size() != n.stat.size {
+		return false
+	}
+
+	sum, err := n.sum(name)
+	if err != nil {
+		return false
+	}
+
+	f, err := os.Open(target)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	existingSum, err := hashReader(f)
+
+	return err == nil && existingSum == sum
+}
+
+// restore applies the mode and modification time to the named OS file.
+func restore(name string, stat info) error {
+	if err := os.Chmod(name, stat.mode.Perm()); err != nil {
+		return err
+	}
+
+	if stat.modTime.IsZero() {
+		return nil
+	}
+
+	return os.Chtimes(name, stat.modTime, stat.modTime)
+}
+
+func isDir(name string) bool {
+	stat, err := os.Stat(name)
+	return err == nil && stat.IsDir()
+}
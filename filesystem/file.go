@@ -1,31 +1,86 @@
 package filesystem
 
 import (
+	"compress/gzip"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"strings"
-
-	"github.com/pkg/errors"
 )
 
 type file struct {
 	*strings.Reader
+	name string
 	stat os.FileInfo
 }
 
+// streamFile reads its data on demand from a stream, such as a decompressor,
+// which can be restarted but not seeked. Seeking only moves the read
+// position, which the stream catches up with on the next Read.
+type streamFile struct {
+	name   string
+	stat   os.FileInfo
+	stream func() (io.ReadCloser, error)
+	pos    int64
+	r      io.ReadCloser
+	rpos   int64
+}
+
 type dir struct {
 	pos   int
+	name  string
 	stat  os.FileInfo
 	files []os.FileInfo
 }
 
-func newFile(data string, stat os.FileInfo) http.File {
-	return file{strings.NewReader(data), stat}
+func newFile(name, data string, stat os.FileInfo) http.File {
+	return file{strings.NewReader(data), name, stat}
+}
+
+func newGzipFile(name, data string, stat os.FileInfo) http.File {
+	return newStreamFile(name, stat, func() (io.ReadCloser, error) {
+		return gzip.NewReader(strings.NewReader(data))
+	})
+}
+
+// gunzip returns a stream that decompresses the gzip compressed data of the
+// given one.
+func gunzip(stream func() (io.ReadCloser, error)) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		r, err := stream()
+		if err != nil {
+			return nil, err
+		}
+
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		return gzipReader{z, r}, nil
+	}
 }
 
-func newDir(stat os.FileInfo, files []os.FileInfo) http.File {
-	return &dir{0, stat, files}
+// gzipReader decompresses the data of an underlying stream, which it closes
+// along with itself.
+type gzipReader struct {
+	*gzip.Reader
+	r io.ReadCloser
+}
+
+func (g gzipReader) Close() error {
+	g.Reader.Close()
+	return g.r.Close()
+}
+
+func newStreamFile(name string, stat os.FileInfo, stream func() (io.ReadCloser, error)) http.File {
+	return &streamFile{name: name, stat: stat, stream: stream}
+}
+
+func newDir(name string, stat os.FileInfo, files []os.FileInfo) http.File {
+	return &dir{0, name, stat, files}
 }
 
 func (f file) Close() error {
@@ -36,8 +91,104 @@ func (f file) Stat() (os.FileInfo, error) {
 	return f.stat, nil
 }
 
+func (f file) Seek(offset int64, whence int) (int64, error) {
+	n, err := f.Reader.Seek(offset, whence)
+	if err != nil {
+		return n, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	return n, nil
+}
+
 func (f file) Readdir(count int) ([]os.FileInfo, error) {
-	return nil, errors.Wrap(os.ErrInvalid, "not a directory")
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+}
+
+func (f *streamFile) Close() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+
+	return err
+}
+
+func (f *streamFile) Stat() (os.FileInfo, error) {
+	return f.stat, nil
+}
+
+func (f *streamFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+}
+
+func (f *streamFile) Read(b []byte) (int, error) {
+	if f.pos >= f.stat.Size() {
+		return 0, io.EOF
+	}
+
+	if f.r == nil || f.rpos > f.pos {
+		if err := f.reset(); err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+	}
+
+	if f.rpos < f.pos {
+		n, err := io.CopyN(io.Discard, f.r, f.pos-f.rpos)
+		f.rpos += n
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+	}
+
+	n, err := f.r.Read(b)
+	f.pos += int64(n)
+	f.rpos += int64(n)
+
+	if err != nil && err != io.EOF {
+		return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+
+	return n, err
+}
+
+func (f *streamFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.stat.Size()
+	default:
+		offset = -1
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	f.pos = offset
+
+	return offset, nil
+}
+
+// reset restarts the stream from the beginning of the data.
+func (f *streamFile) reset() error {
+	if f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+
+	r, err := f.stream()
+	if err != nil {
+		return err
+	}
+
+	f.r = r
+	f.rpos = 0
+
+	return nil
 }
 
 func (d *dir) Close() error {
@@ -46,7 +197,7 @@ func (d *dir) Close() error {
 }
 
 func (d *dir) Seek(int64, int) (int64, error) {
-	return 0, errors.Wrap(os.ErrInvalid, "seek "+d.stat.Name()+"  is a directory")
+	return 0, &os.PathError{Op: "seek", Path: d.name, Err: os.ErrInvalid}
 }
 
 func (d dir) Stat() (os.FileInfo, error) {
@@ -54,28 +205,39 @@ func (d dir) Stat() (os.FileInfo, error) {
 }
 
 func (d dir) Read(b []byte) (int, error) {
-	return 0, errors.Wrap(os.ErrInvalid, "read "+d.stat.Name()+" is a directory")
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: os.ErrInvalid}
 }
 
 func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
-	stats := []os.FileInfo{}
+	files, err := d.next(count)
 
-	if count <= 0 {
-		return d.files, nil
-	}
+	return append([]os.FileInfo{}, files...), err
+}
 
-	count += d.pos
-	if count > len(d.files) {
-		count = len(d.files)
-	}
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	files, err := d.next(count)
 
-	for ; d.pos < count; d.pos++ {
-		stats = append(stats, d.files[d.pos])
-	}
+	return dirEntries(files), err
+}
 
-	if len(stats) == 0 {
-		return stats, io.EOF
+// next advances the read position of the directory, returning at most count
+// entries if count is positive, or all the remaining ones otherwise. As with
+// os.File, io.EOF is only returned for a positive count, when there are no
+// more entries.
+func (d *dir) next(count int) ([]os.FileInfo, error) {
+	files := d.files[d.pos:]
+
+	if count > 0 {
+		if len(files) == 0 {
+			return nil, io.EOF
+		}
+
+		if count < len(files) {
+			files = files[:count]
+		}
 	}
 
-	return stats, nil
+	d.pos += len(files)
+
+	return files, nil
 }
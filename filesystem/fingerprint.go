@@ -0,0 +1,145 @@
+package filesystem
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// AddFingerprint registers an alias for the named file, which usually
+// contains a hash of its content, such as app.3f9a1c2b.js for app.js. The
+// alias shares the file's data, and is served by a Handler with headers that
+// allow it to be cached indefinitely. The mapping between the two names is
+// recorded in the filesystem's manifest, and is resolved by AssetPath.
+//
+// Replacing or removing the file also removes its alias and manifest entry,
+// since its content is no longer that of the alias, while renaming either of
+// them updates the manifest. Aliases stored in packs can't be removed, and
+// only drop out of the manifest.
+func (fs *FileSystem) AddFingerprint(name, alias string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.Sealed() {
+		return &os.PathError{Op: "fingerprint", Path: name, Err: ErrSealed}
+	}
+
+	p, a := clean(name), clean(alias)
+
+	n, ok := fs.lookup(p)
+	if !ok {
+		return &os.PathError{Op: "fingerprint", Path: name, Err: os.ErrNotExist}
+	}
+
+	if n.stat.IsDir() || a == "." {
+		return &os.PathError{Op: "fingerprint", Path: name, Err: os.ErrInvalid}
+	}
+
+	parent, dir, err := fs.mkdirAll(path.Dir(a))
+	if err != nil {
+		return &os.PathError{Op: "fingerprint", Path: alias, Err: err}
+	}
+
+	base := path.Base(a)
+	if fs.conflicts(dir, parent, base) {
+		return &os.PathError{Op: "fingerprint", Path: alias, Err: os.ErrExist}
+	}
+
+	c := *n
+	c.name = base
+	c.stat.name = base
+	c.immutable = true
+
+	fs.attach(dir, parent, &c)
+	fs.manifest[p] = a
+	fs.touch(dir, time.Time{}, c.stat.modTime)
+
+	return nil
+}
+
+// dropFingerprints removes the manifest entries of the files with the given
+// cleaned name, or within it, along with their aliases. Entries whose aliases
+// are within the name are removed as well. The caller is expected to hold the
+// write lock.
+func (fs *FileSystem) dropFingerprints(name string) {
+	for p, a := range fs.manifest {
+		if within(p, name) {
+			delete(fs.manifest, p)
+
+			if within(a, name) {
+				continue
+			}
+
+			if n, err := fs.detach(a, false); err == nil {
+				fs.touch(path.Dir(a), n.stat.modTime, time.Time{})
+			}
+		} else if within(a, name) {
+			delete(fs.manifest, p)
+		}
+	}
+}
+
+// renameFingerprints updates the manifest after the file or directory with the
+// old cleaned name has been renamed. The caller is expected to hold the write
+// lock.
+func (fs *FileSystem) renameFingerprints(oldname, newname string) {
+	manifest := make(map[string]string, len(fs.manifest))
+	for p, a := range fs.manifest {
+		if within(p, oldname) {
+			p = newname + p[len(oldname):]
+		}
+
+		if within(a, oldname) {
+			a = newname + a[len(oldname):]
+		}
+
+		manifest[p] = a
+	}
+
+	fs.manifest = manifest
+}
+
+// within reports whether the cleaned name is, or is within, the directory.
+func within(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// AssetPath returns the fingerprinted alias of the named file, or the name
+// itself if the file has no such alias. A leading slash is preserved. It is
+// meant to be used within templates, e.g. via a template.FuncMap:
+//
+//	template.FuncMap{"asset": fs.AssetPath}
+//
+//	<script src="{{ asset "/js/app.js" }}"></script>
+func (fs *FileSystem) AssetPath(name string) string {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	alias, ok := fs.manifest[clean(name)]
+	if !ok {
+		return name
+	}
+
+	if strings.HasPrefix(name, "/") {
+		return "/" + alias
+	}
+
+	return alias
+}
+
+// Manifest returns a copy of the mapping between file names and their
+// fingerprinted aliases.
+func (fs *FileSystem) Manifest() map[string]string {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	manifest := make(map[string]string, len(fs.manifest))
+	for name, alias := range fs.manifest {
+		manifest[name] = alias
+	}
+
+	return manifest
+}
@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -18,20 +19,79 @@ import (
 // such entries can be effectively embedded into the resultant binary.
 type FileSystem struct {
 	// Fallback instructs the filesystem to fall back to the operating system
-	// if a file hasn't beed aded to it.
+	// if a file hasn't beed aded to it. Names are resolved relative to, and
+	// confined to, the current working directory of the process, as with a
+	// FallbackFS of Dir("."). It is ignored when FallbackFS is set.
 	Fallback bool
 
-	mutex *sync.RWMutex
-	root  node
+	// FallbackFS, when set, is used to open any file that hasn't been added
+	// to the filesystem. A Dir may be used to fall back to a specific
+	// directory of the operating system, while http.FS converts any fs.FS.
+	// It is passed slash-rooted names, such as "/css/app.css".
+	FallbackFS http.FileSystem
+
+	// CaseInsensitive makes names match files whose names only differ by
+	// case, using Unicode case folding. Files whose names match exactly are
+	// preferred, followed by added files over packed ones, and then by the
+	// files with the lexically smallest names.
+	//
+	// It should be set before adding files, since Add and the other methods
+	// that create files then refuse names that only differ by case from
+	// existing ones in the same directory with an error satisfying
+	// os.IsExist, and parent directories are matched to existing ones,
+	// including packed ones, regardless of case.
+	CaseInsensitive bool
+
+	mutex    *sync.RWMutex
+	root     *node
+	manifest map[string]string
+	// paths maps the cleaned names of all nodes in the tree to them, so that
+	// they can be found without walking the tree.
+	paths map[string]*node
+	// packs hold files that are looked up after the ones in the tree.
+	packs []*pack
+	// packed caches the listings of packed directories that aren't in the
+	// tree, by name. Since packs are immutable, it is only replaced when one
+	// is added.
+	packed *sync.Map
+	// sealed is set atomically by Seal, after which reads aren't locked.
+	sealed int32
 }
 
 type node struct {
 	name     string
-	children map[string]node
-	stat     os.FileInfo
+	children map[string]*node
+	stat     info
 	data     string
+	// implicit marks directories that were created as parents of other
+	// entries, whose metadata is derived from their children.
+	implicit bool
+	gzip     bool
+	hash     string
+	ctype    string
+	// stream, when set, provides the file data on demand, in place of data.
+	// The data is provided as it is stored, so it is still compressed if gzip
+	// is set.
+	stream func() (io.ReadCloser, error)
+	// immutable marks fingerprinted aliases, whose content never changes.
+	immutable bool
+	// listing caches the sorted children of a directory in the tree. It is
+	// replaced whenever they change.
+	listing *listing
+}
+
+// listing holds the children of a directory, including packed ones, sorted by
+// name. It is computed on first use.
+type listing struct {
+	once  sync.Once
+	nodes []*node
+	files []os.FileInfo
 }
 
+// An Option describes additional properties of a file added to a FileSystem,
+// such as how its data is stored.
+type Option func(n *node)
+
 type payload struct {
 	stat os.FileInfo
 	data string
@@ -39,10 +99,17 @@ type payload struct {
 
 // New creates a fresh instance of a FileSystem
 func New() *FileSystem {
-	return &FileSystem{
-		mutex: &sync.RWMutex{},
-		root:  node{"", map[string]node{}, dirStat(""), ""},
+	fs := &FileSystem{
+		mutex:    &sync.RWMutex{},
+		root:     implicitDir(""),
+		manifest: map[string]string{},
+		paths:    map[string]*node{},
+		packed:   &sync.Map{},
 	}
+
+	fs.register(".", fs.root)
+
+	return fs
 }
 
 // Add inserts a new named file representation into the filesystem. The size,
@@ -58,101 +125,832 @@ func New() *FileSystem {
 //	/absolute/path		->	/absolute/path
 func (fs *FileSystem) Add(
 	name string, size int64, mode os.FileMode, modTime time.Time, data string,
+	options ...Option,
 ) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
-	name = path.Clean(filepath.ToSlash(name))
-	if path.IsAbs(name) {
-		name = name[1:]
+	if fs.Sealed() {
+		return &os.PathError{Op: "add", Path: name, Err: ErrSealed}
 	}
 
-	parts := strings.Split(name, "/")
-	stat := info{parts[len(parts)-1], size, mode, modTime}
-	n := &fs.root
-	for i, p := range parts {
-		if i == len(parts)-1 {
-			// Leaf
-			if _, ok := n.children[p]; ok {
-				return os.ErrExist
-			}
-			var children map[string]node
-			if stat.IsDir() {
-				children = map[string]node{}
-			}
+	p := clean(name)
+	if p == "." {
+		return &os.PathError{Op: "add", Path: name, Err: os.ErrExist}
+	}
 
-			n.children[p] = node{p, children, stat, data}
-			break
+	parent, dir, err := fs.mkdirAll(path.Dir(p))
+	if err != nil {
+		return &os.PathError{Op: "add", Path: name, Err: err}
+	}
+
+	base := path.Base(p)
+	if fs.conflicts(dir, parent, base) {
+		return &os.PathError{Op: "add", Path: name, Err: os.ErrExist}
+	}
+
+	fs.attach(dir, parent, newNode(info{base, size, mode, modTime}, data, options...))
+	fs.touch(dir, time.Time{}, modTime)
+
+	return nil
+}
+
+// AddDir inserts a directory with the given mode and modification time into
+// the filesystem. If the directory has already been created implicitly, by
+// adding a file within it, its metadata is updated instead.
+//
+// Directories that haven't been added explicitly have a mode of 0755, and the
+// modification time of their newest child.
+func (fs *FileSystem) AddDir(name string, mode os.FileMode, modTime time.Time) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.Sealed() {
+		return &os.PathError{Op: "add", Path: name, Err: ErrSealed}
+	}
+
+	p := clean(name)
+	stat := info{path.Base(p), 0, mode | os.ModeDir, modTime}
+
+	var n *node
+	if p == "." {
+		n = fs.root
+		stat.name = ""
+	} else {
+		parent, dir, err := fs.mkdirAll(path.Dir(p))
+		if err != nil {
+			return &os.PathError{Op: "add", Path: name, Err: err}
 		}
 
-		if c, ok := n.children[p]; ok {
-			if !c.stat.IsDir() {
-				return os.ErrExist
-			}
-			n = &c
+		if c, ok := fs.child(parent, stat.name); ok {
+			n = c
+			stat.name = c.name
+		} else if stat.name = fs.packedDir(dir, stat.name); fs.conflicts(dir, parent, stat.name) {
+			return &os.PathError{Op: "add", Path: name, Err: os.ErrExist}
 		} else {
-			c := node{p, map[string]node{}, dirStat(p), ""}
-			n.children[p] = c
-			n = &c
+			fs.attach(dir, parent, newNode(stat, ""))
+			fs.touch(dir, time.Time{}, modTime)
+
+			return nil
+		}
+	}
+
+	if !n.implicit {
+		return &os.PathError{Op: "add", Path: name, Err: os.ErrExist}
+	}
+
+	previous := n.stat.modTime
+	n.stat = stat
+	n.implicit = false
+	fs.touch(path.Dir(p), previous, modTime)
+
+	return nil
+}
+
+// Gzipped marks the data of an added file as gzip compressed. The file is
+// decompressed lazily when read, while the size passed to Add, and reported
+// by its Stat method, should be that of the uncompressed data.
+func Gzipped() Option {
+	return func(n *node) {
+		n.gzip = true
+	}
+}
+
+// ContentType records the MIME type of an added file, to be used by a Handler
+// instead of guessing it from the file's extension or content.
+func ContentType(ctype string) Option {
+	return func(n *node) {
+		n.ctype = ctype
+	}
+}
+
+// Replace inserts a new named file representation into the filesystem, or
+// updates the existing one with the same name. The parameters have the same
+// meaning as with Add. A directory may only be replaced by another directory,
+// in which case its contents are kept.
+func (fs *FileSystem) Replace(
+	name string, size int64, mode os.FileMode, modTime time.Time, data string,
+	options ...Option,
+) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.Sealed() {
+		return &os.PathError{Op: "replace", Path: name, Err: ErrSealed}
+	}
+
+	p := clean(name)
+	if p == "." {
+		return &os.PathError{Op: "replace", Path: name, Err: os.ErrInvalid}
+	}
+
+	if fs.CaseInsensitive {
+		// Replacing the existing file whose name only differs by case
+		if _, real, ok := fs.get(p); ok {
+			p = real
+		}
+	}
+
+	parent, dir, err := fs.mkdirAll(path.Dir(p))
+	if err != nil {
+		return &os.PathError{Op: "replace", Path: name, Err: err}
+	}
+
+	base := path.Base(p)
+	stat := info{base, size, mode, modTime}
+
+	var previous time.Time
+	if c, ok := parent.children[base]; ok {
+		if c.stat.IsDir() != stat.IsDir() {
+			return &os.PathError{Op: "replace", Path: name, Err: os.ErrExist}
+		}
+
+		previous = c.stat.modTime
+		if c.stat.IsDir() {
+			c.stat = stat
+			c.implicit = false
+			fs.touch(dir, previous, modTime)
+
+			return nil
+		}
+	}
+
+	fs.dropFingerprints(path.Join(dir, base))
+	fs.attach(dir, parent, newNode(stat, data, options...))
+	fs.touch(dir, previous, modTime)
+
+	return nil
+}
+
+// Remove deletes the named file from the filesystem. If it is a directory,
+// all of its contents are removed as well. When prune is true, any parent
+// directories left empty after the removal are also deleted.
+func (fs *FileSystem) Remove(name string, prune bool) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.Sealed() {
+		return &os.PathError{Op: "remove", Path: name, Err: ErrSealed}
+	}
+
+	p := clean(name)
+	if p == "." {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrInvalid}
+	}
+
+	_, real, _ := fs.get(p)
+
+	n, err := fs.detach(p, prune)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	fs.dropFingerprints(real)
+
+	fs.touch(path.Dir(p), n.stat.modTime, time.Time{})
+
+	return nil
+}
+
+// Rename moves the file, or directory, from the old name to the new one.
+// Missing parent directories of the new name are created, and if prune is
+// true, parent directories of the old name left empty after the move are
+// deleted. The new name must not already exist.
+//
+// As with os.Rename, any returned error is of type *os.LinkError.
+func (fs *FileSystem) Rename(oldname, newname string, prune bool) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.Sealed() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: ErrSealed}
+	}
+
+	if err := fs.rename(clean(oldname), clean(newname), prune); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+
+	return nil
+}
+
+func (fs *FileSystem) rename(oldname, newname string, prune bool) error {
+	if oldname == "." || newname == "." || strings.HasPrefix(newname+"/", oldname+"/") {
+		return os.ErrInvalid
+	}
+
+	_, oldname, ok := fs.get(oldname)
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	// Only the case of the name may be changed in a case-insensitive
+	// filesystem
+	if _, real, ok := fs.get(newname); ok && (real != oldname || !fs.CaseInsensitive) {
+		return os.ErrExist
+	}
+
+	if fs.CaseInsensitive {
+		for dir := path.Dir(newname); dir != "."; dir = path.Dir(dir) {
+			if _, real, ok := fs.get(dir); ok && real == oldname {
+				return os.ErrInvalid
+			}
 		}
 	}
 
+	// Check the destination before modifying the tree, so that a failure
+	// doesn't lose the renamed node.
+	if err := fs.checkDirs(path.Dir(newname)); err != nil {
+		return err
+	}
+
+	n, err := fs.detach(oldname, false)
+	if err != nil {
+		return err
+	}
+
+	parent, dir, err := fs.mkdirAll(path.Dir(newname))
+	if err != nil {
+		return err
+	}
+
+	n.name = path.Base(newname)
+	n.stat.name = n.name
+	fs.attach(dir, parent, n)
+	fs.renameFingerprints(oldname, path.Join(dir, n.name))
+
+	// Only pruned once the node is attached, so that the parents it shares
+	// with the new name keep their metadata
+	if prune {
+		fs.prune(path.Dir(oldname))
+	}
+
+	fs.touch(path.Dir(oldname), n.stat.modTime, time.Time{})
+	fs.touch(dir, time.Time{}, n.stat.modTime)
+
 	return nil
 }
 
 // Opens a previously inserted named file. If such a file hasn't been added, it
 // may optionally fall back to accessing the file with the same path in the
 // operating system.
+//
+// Any error returned for files that have been added, or when there is no
+// fallback, is of type *os.PathError.
 func (fs *FileSystem) Open(name string) (http.File, error) {
-	fs.mutex.RLock()
-	defer fs.mutex.RUnlock()
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
 
-	name = path.Clean(filepath.ToSlash(name))
-	if path.IsAbs(name) {
-		name = name[1:]
-		if name == "" {
-			name = "."
+	p := clean(name)
+
+	n, real, ok := fs.resolve(p, true)
+	if !ok {
+		if fallback := fs.fallback(); fallback != nil {
+			return fallback.Open(rooted(p))
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	if n.stat.IsDir() {
+		return newDir(name, n.stat, fs.list(real, n)), nil
+	}
+
+	return n.open(name), nil
+}
+
+// open returns an http.File for the file node under the given name.
+func (n *node) open(name string) http.File {
+	if n.stream != nil && n.gzip {
+		return newStreamFile(name, n.stat, gunzip(n.stream))
+	} else if n.stream != nil {
+		return newStreamFile(name, n.stat, n.stream)
+	} else if n.gzip {
+		return newGzipFile(name, n.data, n.stat)
+	} else {
+		return newFile(name, n.data, n.stat)
+	}
+}
+
+// bytes returns a copy of the, possibly decompressed or streamed, node data.
+func (n *node) bytes(name string) ([]byte, error) {
+	if !n.gzip && n.stream == nil {
+		return []byte(n.data), nil
+	}
+
+	b := make([]byte, n.stat.size)
+	if _, err := io.ReadFull(n.open(name), b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// stored returns a copy of the node data as it is stored, reading it from its
+// stream if it has one.
+func (n *node) stored() ([]byte, error) {
+	if n.stream == nil {
+		return []byte(n.data), nil
+	}
+
+	r, err := n.stream()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// fallback returns the filesystem used to open files that haven't been
+// added, or nil if there is none.
+func (fs *FileSystem) fallback() http.FileSystem {
+	if fs.FallbackFS != nil {
+		return fs.FallbackFS
+	}
+
+	if fs.Fallback {
+		return Dir(".")
+	}
+
+	return nil
+}
+
+// get walks the node tree for the given cleaned name, and searches the packs
+// if it isn't found there. Symbolic links aren't resolved. The caller is
+// expected to hold the read lock.
+func (fs *FileSystem) get(name string) (*node, string, bool) {
+	n, ok := fs.paths[name]
+	if !ok && fs.CaseInsensitive {
+		n, name, ok = fs.walkFolded(name)
+	}
+
+	if ok && !n.implicit {
+		return n, name, true
+	}
+
+	for _, p := range fs.packs {
+		pn, found := p.lookup(name)
+		if !found {
+			continue
+		}
+
+		if !ok {
+			return pn, name, true
+		}
+
+		// An implicit directory takes the metadata of a packed one
+		if pn.stat.IsDir() {
+			c := *n
+			c.stat = pn.stat
+			return &c, name, true
 		}
 	}
 
+	if !ok && fs.CaseInsensitive && len(fs.packs) > 0 {
+		if real, found := fs.fold(name); found && real != name {
+			return fs.get(real)
+		}
+	}
+
+	return n, name, ok
+}
+
+// walkFolded walks the node tree for the given cleaned name, matching names
+// regardless of case. It returns the last node found, along with the actual
+// name of the file if it was found.
+func (fs *FileSystem) walkFolded(name string) (*node, string, bool) {
 	parts := strings.Split(name, "/")
+
+	n, ok := fs.root, true
+	for i, p := range parts {
+		if p == "." {
+			continue
+		}
+
+		if n, ok = fs.child(n, p); !ok {
+			return nil, name, false
+		}
+
+		parts[i] = n.name
+	}
+
+	return n, strings.Join(parts, "/"), true
+}
+
+// child returns the named child of the directory node. If the filesystem is
+// case-insensitive and there is no exact match, the child with the smallest
+// name among those that only differ by case is returned.
+func (fs *FileSystem) child(n *node, name string) (*node, bool) {
+	c, ok := n.children[name]
+	if ok || !fs.CaseInsensitive {
+		return c, ok
+	}
+
+	for base, cc := range n.children {
+		if strings.EqualFold(base, name) && (c == nil || base < c.name) {
+			c = cc
+		}
+	}
+
+	return c, c != nil
+}
+
+// fold returns the actual name of the file matching the given cleaned name,
+// regardless of case, including packed files. The caller is expected to hold
+// the read lock.
+func (fs *FileSystem) fold(name string) (string, bool) {
+	n, current := fs.root, "."
+	for _, p := range strings.Split(name, "/") {
+		if p == "." {
+			continue
+		}
+
+		var match *node
+		for _, c := range fs.children(current, n) {
+			if c.name == p {
+				match = c
+				break
+			} else if match == nil && strings.EqualFold(c.name, p) {
+				match = c
+			}
+		}
+
+		if match == nil {
+			return "", false
+		}
+
+		n, current = match, path.Join(current, match.name)
+	}
+
+	return current, true
+}
+
+// conflicts reports whether the named directory node already has a child
+// with the given name, or, if the filesystem is case-insensitive, a child or
+// a packed file whose name only differs by case. The caller is expected to
+// hold the write lock.
+func (fs *FileSystem) conflicts(dir string, parent *node, base string) bool {
+	if _, ok := fs.child(parent, base); ok {
+		return true
+	}
+
+	if !fs.CaseInsensitive {
+		return false
+	}
+
+	for _, p := range fs.packs {
+		for _, c := range p.children(dir) {
+			if c.name != base && strings.EqualFold(c.name, base) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// packedDir returns the name of the packed directory within the named one
+// that matches the given name regardless of case, if the filesystem is
+// case-insensitive, or the name itself otherwise. The caller is expected to
+// hold the read lock.
+func (fs *FileSystem) packedDir(dir, name string) string {
+	if !fs.CaseInsensitive {
+		return name
+	}
+
+	match := ""
+	for _, p := range fs.packs {
+		for _, c := range p.children(dir) {
+			if !c.stat.IsDir() || !strings.EqualFold(c.name, name) {
+				continue
+			}
+
+			if c.name == name {
+				return name
+			} else if match == "" || c.name < match {
+				match = c.name
+			}
+		}
+	}
+
+	if match == "" {
+		return name
+	}
+
+	return match
+}
+
+// mkdirAll returns the directory node for the given cleaned name, along with
+// its actual name, creating it and any missing parents. Missing parents take
+// the names of the packed directories they extend. The caller is expected to
+// hold the write lock.
+func (fs *FileSystem) mkdirAll(name string) (*node, string, error) {
+	n, dir := fs.root, "."
+	for _, p := range strings.Split(name, "/") {
+		if p == "." {
+			continue
+		}
+
+		c, ok := fs.child(n, p)
+		if !ok {
+			base := fs.packedDir(dir, p)
+			if fs.conflicts(dir, n, base) {
+				return nil, "", os.ErrExist
+			}
+
+			c = implicitDir(base)
+			fs.attach(dir, n, c)
+		} else if !c.stat.IsDir() {
+			return nil, "", os.ErrExist
+		}
+
+		n, dir = c, path.Join(dir, c.name)
+	}
+
+	return n, dir, nil
+}
+
+// attach inserts the node as a child of the named directory node, and
+// registers it along with its descendants. The caller is expected to hold the
+// write lock.
+func (fs *FileSystem) attach(dir string, parent, n *node) {
+	if c, ok := parent.children[n.name]; ok {
+		fs.unregister(path.Join(dir, n.name), c)
+	}
+
+	parent.children[n.name] = n
+	fs.register(path.Join(dir, n.name), n)
+}
+
+// register adds the named node and its descendants to the path index, and
+// resets the listings of the directories among them, which include packed
+// files by name. The caller is expected to hold the write lock.
+func (fs *FileSystem) register(name string, n *node) {
+	fs.paths[name] = n
+	if !n.stat.IsDir() {
+		return
+	}
+
+	n.listing = &listing{}
+	for base, c := range n.children {
+		fs.register(path.Join(name, base), c)
+	}
+}
+
+// unregister removes the named node and its descendants from the path index.
+// The caller is expected to hold the write lock.
+func (fs *FileSystem) unregister(name string, n *node) {
+	delete(fs.paths, name)
+	for base, c := range n.children {
+		fs.unregister(path.Join(name, base), c)
+	}
+}
+
+// touch updates the modification times of the implicit directories leading
+// to, and including, the given cleaned directory name, after the modification
+// time of one of the directory's children has changed from one value to
+// another, with a zero value for added and removed children. Since an
+// implicit directory has the modification time of its newest child, its
+// children only have to be scanned when the time decreases. The caller is
+// expected to hold the write lock.
+func (fs *FileSystem) touch(name string, from, to time.Time) {
+	dirs := []*node{fs.root}
+
 	n := fs.root
-	for _, p := range parts {
+	for _, p := range strings.Split(name, "/") {
 		if p == "." {
 			continue
-		} else {
-			c, ok := n.children[p]
-			if !ok {
-				if fs.Fallback {
-					return os.Open(name)
-				}
-				return nil, os.ErrNotExist
+		}
+
+		c, ok := fs.child(n, p)
+		if !ok {
+			break
+		}
+
+		dirs = append(dirs, c)
+		n = c
+	}
+
+	// The children, or their modification times, have changed
+	for _, d := range dirs {
+		d.listing = &listing{}
+	}
+
+	if !to.Before(from) {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			d := dirs[i]
+			if !d.implicit || !to.After(d.stat.modTime) {
+				break
 			}
 
-			n = c
+			d.stat.modTime = to
 		}
+
+		return
 	}
 
-	if n.stat.IsDir() {
-		files := []os.FileInfo{}
-		names := make([]string, 0, len(n.children))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if !d.implicit || len(d.children) == 0 {
+			continue
+		}
+
+		var modTime time.Time
+		for _, c := range d.children {
+			if c.stat.modTime.After(modTime) {
+				modTime = c.stat.modTime
+			}
+		}
+
+		d.stat.modTime = modTime
+	}
+}
 
-		for name := range n.children {
-			names = append(names, name)
+// checkDirs verifies that mkdirAll would succeed for the given cleaned name,
+// without modifying the tree.
+func (fs *FileSystem) checkDirs(name string) error {
+	n, dir := fs.root, "."
+	for _, p := range strings.Split(name, "/") {
+		if p == "." {
+			continue
 		}
 
-		sort.Strings(names)
+		c, ok := fs.child(n, p)
+		if !ok {
+			if fs.conflicts(dir, n, fs.packedDir(dir, p)) {
+				return os.ErrExist
+			}
 
-		for _, name := range names {
-			files = append(files, n.children[name].stat)
+			return nil
+		} else if !c.stat.IsDir() {
+			return os.ErrExist
 		}
 
-		return newDir(n.stat, files), nil
-	} else {
-		return newFile(n.data, n.stat), nil
+		n, dir = c, path.Join(dir, c.name)
+	}
+
+	return nil
+}
+
+// detach removes the node with the given cleaned name from its parent and
+// returns it. If prune is true, emptied parent directories are removed as
+// well. The caller is expected to hold the write lock.
+func (fs *FileSystem) detach(name string, prune bool) (*node, error) {
+	parts := strings.Split(name, "/")
+	dirs := make([]*node, 0, len(parts))
+
+	n := fs.root
+	for i, p := range parts {
+		c, ok := fs.child(n, p)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		dirs = append(dirs, n)
+		parts[i] = c.name
+		n = c
+	}
+
+	for i := len(parts) - 1; i >= 0; i-- {
+		fs.unregister(strings.Join(parts[:i+1], "/"), dirs[i].children[parts[i]])
+		delete(dirs[i].children, parts[i])
+
+		if !prune || i == 0 || len(dirs[i].children) > 0 {
+			break
+		}
+	}
+
+	return n, nil
+}
+
+// prune removes the directory node with the given actual name, and its
+// parents, for as long as they are empty. The caller is expected to hold the
+// write lock.
+func (fs *FileSystem) prune(name string) {
+	for name != "." {
+		n, ok := fs.paths[name]
+		if !ok || !n.stat.IsDir() || len(n.children) > 0 {
+			return
+		}
+
+		fs.detach(name, false)
+		name = path.Dir(name)
 	}
 }
 
-func dirStat(name string) os.FileInfo {
-	return info{name, 4096, 0x800001ed, time.Now()}
+// list returns the file information of the children of the named directory
+// node, sorted by name.
+func (fs *FileSystem) list(name string, n *node) []os.FileInfo {
+	return fs.listing(name, n).files
+}
+
+// children returns the child nodes of the named directory node, including
+// the packed ones that aren't shadowed by the tree, sorted by name.
+func (fs *FileSystem) children(name string, n *node) []*node {
+	return fs.listing(name, n).nodes
+}
+
+// listing returns the cached listing of the named directory node, computing
+// it on first use. The returned slices must not be modified.
+func (fs *FileSystem) listing(name string, n *node) *listing {
+	l := n.listing
+	if l == nil {
+		// A packed directory, whose nodes are created on each lookup
+		cached, _ := fs.packed.LoadOrStore(name, &listing{})
+		l = cached.(*listing)
+	}
+
+	l.once.Do(func() {
+		l.nodes = fs.merge(name, n)
+		l.files = make([]os.FileInfo, 0, len(l.nodes))
+		for _, c := range l.nodes {
+			l.files = append(l.files, c.stat)
+		}
+	})
+
+	return l
+}
+
+// merge combines the children of the named directory node with those of the
+// packs, sorted by name.
+func (fs *FileSystem) merge(name string, n *node) []*node {
+	children := make([]*node, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+
+	if len(fs.packs) > 0 {
+		seen := make(map[string]bool, len(n.children))
+		for base := range n.children {
+			seen[base] = true
+		}
+
+		for _, p := range fs.packs {
+			for _, c := range p.children(name) {
+				if !seen[c.name] {
+					seen[c.name] = true
+					children = append(children, c)
+				}
+			}
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].name < children[j].name
+	})
+
+	return children
+}
+
+// clean converts a slash or OS separated name to a relative path from the
+// root of the filesystem. The root itself is represented by ".".
+func clean(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	if path.IsAbs(name) {
+		name = name[1:]
+		if name == "" {
+			name = "."
+		}
+	}
+
+	return name
+}
+
+// rooted converts a cleaned name to the slash-rooted form that callers of an
+// http.FileSystem use. Names leading outside of the root are returned as they
+// are, so that a fallback may reject them.
+func rooted(name string) string {
+	switch {
+	case name == ".":
+		return "/"
+	case name == "..", strings.HasPrefix(name, "../"):
+		return name
+	default:
+		return "/" + name
+	}
+}
+
+func newNode(stat info, data string, options ...Option) *node {
+	var children map[string]*node
+	if stat.IsDir() {
+		children = map[string]*node{}
+	}
+
+	n := &node{name: stat.name, children: children, stat: stat, data: data}
+	for _, o := range options {
+		o(n)
+	}
+
+	return n
+}
+
+// implicitDir creates a directory node with a fixed mode, whose modification
+// time will be that of the newest of its children.
+func implicitDir(name string) *node {
+	return &node{
+		name:     name,
+		children: map[string]*node{},
+		stat:     info{name, 0, 0x800001ed, time.Time{}},
+		implicit: true,
+	}
 }
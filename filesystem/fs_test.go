@@ -1,10 +1,21 @@
 package filesystem
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	iofs "io/fs"
+	"net/http"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/pkg/errors"
@@ -178,3 +189,2008 @@ func TestDir(t *testing.T) {
 		})
 	}
 }
+
+func TestModify(t *testing.T) {
+	fs := New()
+
+	for _, f := range files {
+		if f.name != "" {
+			fs.Add(f.name, f.stat.Size(), f.stat.Mode(), f.stat.ModTime(), f.data)
+		}
+	}
+
+	if err := fs.Replace("foo", 3, 0x1a4, now, "abc"); err != nil {
+		t.Fatalf("replacing file: %+v", err)
+	}
+
+	if err := fs.Replace("e/delta", 3, 0x1a4, now, "def"); err != nil {
+		t.Fatalf("replacing missing file: %+v", err)
+	}
+
+	if err := fs.Replace("d", 3, 0x1a4, now, "abc"); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist when replacing a directory, got %+v", err)
+	}
+
+	if err := fs.Rename("d/alpha", "f/g/alpha", true); err != nil {
+		t.Fatalf("renaming file: %+v", err)
+	}
+
+	if err := fs.Rename("d/beta", "foo", false); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist when renaming over a file, got %+v", err)
+	}
+
+	if err := fs.Rename("d", "d/h", false); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid when renaming into itself, got %+v", err)
+	}
+
+	if err := fs.Rename("missing", "found", false); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	if err := fs.Remove("d/beta", false); err != nil {
+		t.Fatalf("removing file: %+v", err)
+	}
+
+	if err := fs.Remove("d/gamma", false); err != nil {
+		t.Fatalf("removing file: %+v", err)
+	}
+
+	if err := fs.Remove("e/delta", true); err != nil {
+		t.Fatalf("removing file: %+v", err)
+	}
+
+	if err := fs.Remove("e/delta", true); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	if err := fs.Remove("f", false); err != nil {
+		t.Fatalf("removing directory: %+v", err)
+	}
+
+	cases := []struct {
+		name   string
+		exists bool
+		data   string
+	}{
+		{"foo", true, "abc"},
+		{"bar", true, "98765432"},
+		{"d", true, ""},
+		{"d/alpha", false, ""},
+		{"d/beta", false, ""},
+		{"e", false, ""},
+		{"e/delta", false, ""},
+		{"f", false, ""},
+		{"f/g/alpha", false, ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if !tc.exists {
+				if !os.IsNotExist(errors.Cause(err)) {
+					t.Fatalf("expected ErrNotExist, got %+v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+
+			stat, err := f.Stat()
+			if err != nil {
+				t.Fatalf("file stat: %+v", err)
+			}
+
+			if stat.IsDir() {
+				return
+			}
+
+			b := make([]byte, stat.Size())
+			if _, err := f.Read(b); err != nil {
+				t.Fatalf("reading file: %+v", err)
+			}
+
+			if string(b) != tc.data {
+				t.Fatalf("expected data %s, got %s", tc.data, string(b))
+			}
+		})
+	}
+}
+
+func TestFallbackFS(t *testing.T) {
+	fs := New()
+	fs.Add("foo", 4, 0x1a4, now, "1234")
+	fs.FallbackFS = rootedFS{http.FS(fstest.MapFS{
+		"foo":     {Data: []byte("shadowed")},
+		"d/alpha": {Data: []byte("fallback")},
+	})}
+
+	cases := []struct {
+		name   string
+		exists bool
+		data   string
+	}{
+		{"foo", true, "1234"},
+		{"/d/alpha", true, "fallback"},
+		{"d/beta", false, ""},
+		{"fs_test.go", false, ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if !tc.exists {
+				if !os.IsNotExist(errors.Cause(err)) {
+					t.Fatalf("expected ErrNotExist, got %+v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+
+			b, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("reading file: %+v", err)
+			}
+
+			if string(b) != tc.data {
+				t.Fatalf("expected data %s, got %s", tc.data, string(b))
+			}
+		})
+	}
+
+	if b, err := iofs.ReadFile(fs.FS(), "d/alpha"); err != nil || string(b) != "fallback" {
+		t.Fatalf("expected data %q, got %q %+v", "fallback", b, err)
+	}
+
+	if stat, err := iofs.Stat(fs.FS(), "d/alpha"); err != nil || stat.Size() != 8 {
+		t.Fatalf("expected the fallback file info, got %v %+v", stat, err)
+	}
+
+	if entries, err := iofs.ReadDir(fs.FS(), "d"); err != nil || len(entries) != 1 {
+		t.Fatalf("expected the fallback dir entries, got %v %+v", entries, err)
+	}
+
+	if stat, err := fs.Lstat("d/alpha"); err != nil || stat.Size() != 8 {
+		t.Fatalf("expected the fallback file info, got %v %+v", stat, err)
+	}
+}
+
+// rootedFS only opens slash-rooted names, as passed by an http.FileServer.
+type rootedFS struct {
+	http.FileSystem
+}
+
+func (fs rootedFS) Open(name string) (http.File, error) {
+	if !strings.HasPrefix(name, "/") {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return fs.FileSystem.Open(name)
+}
+
+func TestFallbackTraversal(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		filepath.Join(tmp, "outside"):       "outside",
+		filepath.Join(root, "inside"):       "inside",
+		filepath.Join(root, "sub", "other"): "other",
+	} {
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for link, target := range map[string]string{
+		filepath.Join(root, "escape"):        filepath.Join("..", "outside"),
+		filepath.Join(root, "absolute"):      filepath.Join(tmp, "outside"),
+		filepath.Join(root, "sub", "parent"): "..",
+		filepath.Join(root, "sub", "alias"):  filepath.Join("..", "inside"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	fs := New()
+	fs.FallbackFS = Dir(root)
+
+	cases := []struct {
+		name      string
+		data      string
+		traversal bool
+	}{
+		{"inside", "inside", false},
+		{"/sub/other", "other", false},
+		{"sub/alias", "inside", false},
+		{"sub/parent/inside", "inside", false},
+		{"/../outside", "", false},
+		{"../outside", "", true},
+		{"sub/../../outside", "", true},
+		{"..", "", true},
+		{"escape", "", true},
+		{"absolute", "", true},
+		{"sub/parent/escape", "", true},
+		{"sub/parent/../outside", "", false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if tc.traversal {
+				var te *TraversalError
+				if !errors.As(err, &te) || !errors.Is(err, os.ErrPermission) {
+					t.Fatalf("expected *TraversalError, got %+v", err)
+				}
+
+				return
+			}
+
+			if tc.data == "" {
+				if !os.IsNotExist(errors.Cause(err)) {
+					t.Fatalf("expected ErrNotExist, got %+v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+			defer f.Close()
+
+			b, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("reading file: %+v", err)
+			}
+
+			if string(b) != tc.data {
+				t.Fatalf("expected data %s, got %s", tc.data, string(b))
+			}
+		})
+	}
+
+	fs = New()
+	fs.Fallback = true
+
+	var te *TraversalError
+	if _, err := fs.Open("../filesystem/fs_test.go"); err == nil {
+		t.Fatalf("expected the fallback to be confined to the working directory")
+	} else if !errors.As(err, &te) {
+		t.Fatalf("expected *TraversalError, got %+v", err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	root := t.TempDir()
+
+	for name, data := range map[string]string{
+		"index.html":              "index",
+		"secret.txt":              "secret",
+		"static/app.css":          "css",
+		"static/img/logo.png":     "png",
+		"assets/js/app.js":        "js",
+		"assets/js/vendor/lib.js": "lib",
+		"assets/other.txt":        "other",
+	} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := New()
+	fs.FallbackFS = Source{Root: Dir(root), Names: []string{"index.html", "static", "./assets/js/..."}}
+
+	cases := []struct {
+		name   string
+		exists bool
+		data   string
+	}{
+		{"/index.html", true, "index"},
+		{"/static/app.css", true, "css"},
+		{"/assets/js/app.js", true, "js"},
+		{"/assets/js/vendor/lib.js", true, "lib"},
+		{"/static", true, ""},
+		{"/assets", true, ""},
+		{"/secret.txt", false, ""},
+		{"/assets/other.txt", false, ""},
+		{"/static/img", false, ""},
+		{"/static/img/logo.png", false, ""},
+		{"/../index.html", true, "index"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if !tc.exists {
+				if !os.IsNotExist(errors.Cause(err)) {
+					t.Fatalf("expected ErrNotExist, got %+v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+			defer f.Close()
+
+			if b, _ := io.ReadAll(f); string(b) != tc.data {
+				t.Fatalf("expected data %q, got %q", tc.data, b)
+			}
+		})
+	}
+
+	fs.FallbackFS = Source{Root: Dir(root), Names: []string{"static"}, Fallback: Dir(root)}
+	if f, err := fs.Open("secret.txt"); err != nil {
+		t.Fatalf("expected the fallback to be used, got %+v", err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fs := New()
+	fs.Add("foo", 4, 0x1a4, now, "1234")
+	fs.Add("d/alpha", 8, 0x1a5, now, "98765432")
+
+	f, _ := fs.Open("/foo")
+	d, _ := fs.Open("/d")
+
+	cases := []struct {
+		op     string
+		path   string
+		err    error
+		target error
+	}{
+		{"add", "foo", fs.Add("foo", 4, 0x1a4, now, "1234"), os.ErrExist},
+		{"add", "foo/bar", fs.Add("foo/bar", 4, 0x1a4, now, "1234"), os.ErrExist},
+		{"replace", "d", fs.Replace("d", 4, 0x1a4, now, "1234"), os.ErrExist},
+		{"remove", "missing", fs.Remove("missing", false), os.ErrNotExist},
+		{"open", "/missing", func() error { _, err := fs.Open("/missing"); return err }(), os.ErrNotExist},
+		{"readdir", "/foo", func() error { _, err := f.Readdir(0); return err }(), os.ErrInvalid},
+		{"seek", "/foo", func() error { _, err := f.Seek(-1, io.SeekStart); return err }(), os.ErrInvalid},
+		{"read", "/d", func() error { _, err := d.Read(nil); return err }(), os.ErrInvalid},
+		{"seek", "/d", func() error { _, err := d.Seek(0, io.SeekStart); return err }(), os.ErrInvalid},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			var pe *os.PathError
+			if !errors.As(tc.err, &pe) {
+				t.Fatalf("expected *os.PathError, got %+v", tc.err)
+			}
+
+			if pe.Op != tc.op || pe.Path != tc.path {
+				t.Fatalf("expected %s %s, got %s %s", tc.op, tc.path, pe.Op, pe.Path)
+			}
+
+			if !errors.Is(tc.err, tc.target) {
+				t.Fatalf("expected %v, got %+v", tc.target, tc.err)
+			}
+		})
+	}
+
+	if err := fs.Rename("missing", "found", false); !os.IsNotExist(err) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	if _, err := fs.Open("missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+}
+
+func TestReaddirSemantics(t *testing.T) {
+	tmp := t.TempDir()
+	fs := New()
+
+	for _, name := range []string{"bar", "foo", "d/alpha"} {
+		if err := os.MkdirAll(filepath.Join(tmp, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		fs.Add(name, int64(len(name)), 0644, now, name)
+	}
+
+	cases := [][]int{
+		{-1, -1},
+		{0, 0},
+		{1, 1, 1, 1},
+		{2, 2, 2},
+		{1, 0, 1},
+		{1, -1, -1},
+		{5, 1},
+		{3, 0},
+	}
+
+	type result struct {
+		n   int
+		nil bool
+		err error
+	}
+
+	readers := map[string]func(f http.File, n int) result{
+		"Readdir": func(f http.File, n int) result {
+			files, err := f.Readdir(n)
+			return result{len(files), files == nil, err}
+		},
+		"ReadDir": func(f http.File, n int) result {
+			entries, err := f.(interface {
+				ReadDir(int) ([]iofs.DirEntry, error)
+			}).ReadDir(n)
+			return result{len(entries), entries == nil, err}
+		},
+	}
+
+	for method, read := range readers {
+		for i, counts := range cases {
+			t.Run(fmt.Sprintf("%s case %d", method, i), func(t *testing.T) {
+				expected, err := os.Open(tmp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer expected.Close()
+
+				f, err := fs.Open("/")
+				if err != nil {
+					t.Fatalf("opening dir: %+v", err)
+				}
+				defer f.Close()
+
+				for j, n := range counts {
+					exp, got := read(expected, n), read(f, n)
+					if exp != got {
+						t.Fatalf("step %d, count %d: expected %+v, got %+v", j, n, exp, got)
+					}
+				}
+			})
+		}
+	}
+
+	f, _ := fs.Open("/")
+	files, _ := f.Readdir(-1)
+	files[0] = nil
+
+	f, _ = fs.Open("/")
+	if files, _ = f.Readdir(-1); files[0] == nil {
+		t.Fatalf("modifying the returned slice shouldn't affect the directory")
+	}
+}
+
+func TestDirMetadata(t *testing.T) {
+	older, newer := time.Unix(1000, 0), time.Unix(2000, 0)
+
+	fs := New()
+	fs.Add("a/b/old", 1, 0644, older, "1")
+	fs.Add("a/b/new", 1, 0644, newer, "2")
+	fs.Add("a/c", 1, 0644, older, "3")
+
+	if err := fs.AddDir("x", 0700, older); err != nil {
+		t.Fatalf("adding dir: %+v", err)
+	}
+	fs.Add("x/y", 1, 0644, newer, "4")
+
+	if err := fs.AddDir("x", 0700, older); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected ErrExist, got %+v", err)
+	}
+
+	if err := fs.AddDir("a/c", 0700, older); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected ErrExist, got %+v", err)
+	}
+
+	stat := func(name string) os.FileInfo {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("opening %s: %+v", name, err)
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			t.Fatalf("stat %s: %+v", name, err)
+		}
+
+		return stat
+	}
+
+	cases := []struct {
+		name    string
+		mode    os.FileMode
+		modTime time.Time
+	}{
+		{"/", os.ModeDir | 0755, newer},
+		{"a", os.ModeDir | 0755, newer},
+		{"a/b", os.ModeDir | 0755, newer},
+		{"x", os.ModeDir | 0700, older},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			s := stat(tc.name)
+			if s.Mode() != tc.mode {
+				t.Fatalf("expected mode %s, got %s", tc.mode, s.Mode())
+			}
+
+			if !s.ModTime().Equal(tc.modTime) {
+				t.Fatalf("expected mod time %s, got %s", tc.modTime, s.ModTime())
+			}
+
+			if s.Size() != 0 {
+				t.Fatalf("expected size 0, got %d", s.Size())
+			}
+		})
+	}
+
+	if err := fs.Remove("a/b/new", false); err != nil {
+		t.Fatalf("removing file: %+v", err)
+	}
+
+	if s := stat("a"); !s.ModTime().Equal(older) {
+		t.Fatalf("expected mod time %s after removal, got %s", older, s.ModTime())
+	}
+
+	if err := fs.AddDir("a/b", 0750, newer); err != nil {
+		t.Fatalf("adding implicit dir: %+v", err)
+	}
+
+	if s := stat("a/b"); s.Mode() != os.ModeDir|0750 || !s.ModTime().Equal(newer) {
+		t.Fatalf("expected explicit metadata, got %s %s", s.Mode(), s.ModTime())
+	}
+
+	if s := stat("a"); !s.ModTime().Equal(newer) {
+		t.Fatalf("expected mod time %s, got %s", newer, s.ModTime())
+	}
+
+	fs.Add("p/q/r", 1, 0644, newer, "5")
+	if err := fs.Replace("p/q/r", 1, 0644, older, "6"); err != nil {
+		t.Fatalf("replacing file: %+v", err)
+	}
+
+	for _, name := range []string{"p", "p/q"} {
+		if s := stat(name); !s.ModTime().Equal(older) {
+			t.Fatalf("expected mod time %s of %s after replacing, got %s", older, name, s.ModTime())
+		}
+	}
+
+	if err := fs.Rename("p/q/r", "a/r", false); err != nil {
+		t.Fatalf("renaming file: %+v", err)
+	}
+
+	if s := stat("p/q"); !s.ModTime().Equal(older) {
+		t.Fatalf("expected the mod time %s of an empty dir to be kept, got %s", older, s.ModTime())
+	}
+
+	// Pruning keeps the explicit parents shared with the new name
+	fs.AddDir("m", 0700, older)
+	fs.AddDir("m/n", 0700, older)
+	fs.Add("m/n/o", 1, 0644, older, "7")
+	fs.AddDir("v/w", 0700, older)
+	fs.Add("v/w/z", 1, 0644, older, "8")
+
+	if err := fs.Rename("m/n/o", "m/n/p", true); err != nil {
+		t.Fatalf("renaming file: %+v", err)
+	}
+
+	if err := fs.Rename("v/w/z", "m/z", true); err != nil {
+		t.Fatalf("renaming file: %+v", err)
+	}
+
+	for _, name := range []string{"m", "m/n"} {
+		if s := stat(name); s.Mode() != os.ModeDir|0700 || !s.ModTime().Equal(older) {
+			t.Fatalf("expected the explicit metadata of %s to be kept, got %s %s", name, s.Mode(), s.ModTime())
+		}
+	}
+
+	if _, err := fs.Open("v"); !os.IsNotExist(err) {
+		t.Fatalf("expected the emptied parents to be pruned, got %+v", err)
+	}
+}
+
+func TestGzipped(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+
+	fs := New()
+	if err := fs.Add("data.txt", int64(len(data)), 0644, now, buf.String(), Gzipped()); err != nil {
+		t.Fatalf("adding file: %+v", err)
+	}
+
+	f, err := fs.Open("data.txt")
+	if err != nil {
+		t.Fatalf("opening file: %+v", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatalf("file stat: %+v", err)
+	}
+
+	if stat.Size() != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), stat.Size())
+	}
+
+	cases := []struct {
+		offset int64
+		whence int
+		pos    int64
+		n      int
+	}{
+		{0, io.SeekStart, 0, 10},
+		{5000, io.SeekStart, 5000, 100},
+		{-10, io.SeekCurrent, 5090, 20},
+		{-5, io.SeekEnd, 9995, 5},
+		{123, io.SeekStart, 123, 4000},
+		{0, io.SeekEnd, 10000, 0},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			pos, err := f.Seek(tc.offset, tc.whence)
+			if err != nil {
+				t.Fatalf("seeking: %+v", err)
+			}
+
+			if pos != tc.pos {
+				t.Fatalf("expected position %d, got %d", tc.pos, pos)
+			}
+
+			b := make([]byte, tc.n)
+			n, err := io.ReadFull(f, b)
+			if err != nil {
+				t.Fatalf("reading: %+v", err)
+			}
+
+			if string(b[:n]) != data[pos:pos+int64(tc.n)] {
+				t.Fatalf("expected data %s, got %s", data[pos:pos+int64(tc.n)], string(b[:n]))
+			}
+		})
+	}
+
+	if _, err := f.Seek(-1, io.SeekStart); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %+v", err)
+	}
+
+	b, err := iofs.ReadFile(fs.FS(), "data.txt")
+	if err != nil {
+		t.Fatalf("reading file: %+v", err)
+	}
+
+	if string(b) != data {
+		t.Fatalf("expected the decompressed data")
+	}
+}
+
+func TestHash(t *testing.T) {
+	data := "0123456789"
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+
+	fs := New()
+	fs.Add("recorded", 10, 0644, now, data, SHA256("recorded-sum"))
+	fs.Add("plain", 10, 0644, now, data)
+	fs.Add("gzipped", 10, 0644, now, buf.String(), Gzipped())
+	fs.Add("d/file", 10, 0644, now, data)
+
+	cases := []struct {
+		name string
+		hash string
+		err  error
+	}{
+		{"recorded", "recorded-sum", nil},
+		{"plain", sum, nil},
+		{"/gzipped", sum, nil},
+		{"d", "", os.ErrInvalid},
+		{"missing", "", os.ErrNotExist},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			hash, err := fs.Hash(tc.name)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected %v, got %+v", tc.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("hashing file: %+v", err)
+			}
+
+			if hash != tc.hash {
+				t.Fatalf("expected hash %s, got %s", tc.hash, hash)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fs := New()
+	fs.Add("js/app.js", 10, 0644, now, "0123456789", SHA256("3f9a1c2b"))
+	fs.Add("d/alpha", 8, 0644, now, "98765432")
+
+	if err := fs.AddFingerprint("js/app.js", "js/app.3f9a1c2b.js"); err != nil {
+		t.Fatalf("adding fingerprint: %+v", err)
+	}
+
+	if err := fs.AddFingerprint("/js/app.js", "/js/app.3f9a1c2b.js"); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected ErrExist, got %+v", err)
+	}
+
+	if err := fs.AddFingerprint("missing.js", "missing.abc.js"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	if err := fs.AddFingerprint("d", "d.abc"); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %+v", err)
+	}
+
+	cases := []struct {
+		name, path string
+	}{
+		{"/js/app.js", "/js/app.3f9a1c2b.js"},
+		{"js/app.js", "js/app.3f9a1c2b.js"},
+		{"./js/app.js", "js/app.3f9a1c2b.js"},
+		{"/d/alpha", "/d/alpha"},
+		{"/missing", "/missing"},
+	}
+
+	for _, tc := range cases {
+		if p := fs.AssetPath(tc.name); p != tc.path {
+			t.Fatalf("expected asset path %s for %s, got %s", tc.path, tc.name, p)
+		}
+	}
+
+	f, err := fs.Open("/js/app.3f9a1c2b.js")
+	if err != nil {
+		t.Fatalf("opening alias: %+v", err)
+	}
+
+	if b, _ := io.ReadAll(f); string(b) != "0123456789" {
+		t.Fatalf("expected the aliased data, got %s", string(b))
+	}
+
+	if stat, _ := f.Stat(); stat.Name() != "app.3f9a1c2b.js" {
+		t.Fatalf("expected the alias name, got %s", stat.Name())
+	}
+
+	manifest := fs.Manifest()
+	if len(manifest) != 1 || manifest["js/app.js"] != "js/app.3f9a1c2b.js" {
+		t.Fatalf("unexpected manifest %v", manifest)
+	}
+
+	// Renaming the directory moves both names in the manifest
+	if err := fs.Rename("js", "scripts", false); err != nil {
+		t.Fatalf("renaming dir: %+v", err)
+	}
+
+	if p := fs.AssetPath("/scripts/app.js"); p != "/scripts/app.3f9a1c2b.js" {
+		t.Fatalf("expected the renamed alias, got %s", p)
+	}
+
+	if err := fs.Rename("scripts/app.3f9a1c2b.js", "app.3f9a1c2b.js", false); err != nil {
+		t.Fatalf("renaming alias: %+v", err)
+	}
+
+	if p := fs.AssetPath("/scripts/app.js"); p != "/app.3f9a1c2b.js" {
+		t.Fatalf("expected the renamed alias, got %s", p)
+	}
+
+	// Replacing the file drops its stale alias
+	if err := fs.Replace("scripts/app.js", 4, 0644, now, "0123"); err != nil {
+		t.Fatalf("replacing file: %+v", err)
+	}
+
+	if p := fs.AssetPath("scripts/app.js"); p != "scripts/app.js" {
+		t.Fatalf("expected no alias after replacing, got %s", p)
+	}
+
+	if _, err := fs.Open("/app.3f9a1c2b.js"); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected the stale alias to be removed, got %+v", err)
+	}
+
+	// Removing the file drops its alias
+	fs.AddFingerprint("d/alpha", "d/alpha.1234")
+	if err := fs.Remove("d/alpha", false); err != nil {
+		t.Fatalf("removing file: %+v", err)
+	}
+
+	if _, err := fs.Open("/d/alpha.1234"); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected the alias to be removed, got %+v", err)
+	}
+
+	if manifest := fs.Manifest(); len(manifest) != 0 {
+		t.Fatalf("expected an empty manifest, got %v", manifest)
+	}
+
+	// Removing the alias drops the manifest entry
+	fs.AddFingerprint("scripts/app.js", "scripts/app.abcd.js")
+	if err := fs.Remove("scripts/app.abcd.js", false); err != nil {
+		t.Fatalf("removing alias: %+v", err)
+	}
+
+	if p := fs.AssetPath("scripts/app.js"); p != "scripts/app.js" {
+		t.Fatalf("expected no alias after removing it, got %s", p)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("compressed data"))
+	w.Close()
+
+	fs := New()
+	modTime := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	fs.Add("foo", 4, 0644, modTime, "1234")
+	fs.Add("d/beta", 8, 0755, modTime, "98765432")
+	fs.Add("d/alpha", 15, 0600, modTime, buf.String(), Gzipped())
+	fs.AddDir("e", os.ModeDir|0700, modTime)
+
+	expected := []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{"d/", os.ModeDir | 0755, ""},
+		{"d/alpha", 0600, "compressed data"},
+		{"d/beta", 0755, "98765432"},
+		{"e/", os.ModeDir | 0700, ""},
+		{"foo", 0644, "1234"},
+	}
+
+	t.Run("tar", func(t *testing.T) {
+		var first, second bytes.Buffer
+		if err := fs.WriteTar(&first); err != nil {
+			t.Fatalf("writing tar: %+v", err)
+		}
+
+		if err := fs.WriteTar(&second); err != nil {
+			t.Fatalf("writing tar: %+v", err)
+		}
+
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("expected identical archives")
+		}
+
+		tr := tar.NewReader(&first)
+		for _, e := range expected {
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatalf("reading tar: %+v", err)
+			}
+
+			if hdr.Name != e.name || hdr.FileInfo().Mode() != e.mode || !hdr.ModTime.Equal(modTime) {
+				t.Fatalf("expected %s %v %v, got %s %v %v", e.name, e.mode, modTime, hdr.Name, hdr.FileInfo().Mode(), hdr.ModTime)
+			}
+
+			if b, _ := io.ReadAll(tr); string(b) != e.data {
+				t.Fatalf("expected data %q for %s, got %q", e.data, e.name, b)
+			}
+		}
+
+		if _, err := tr.Next(); err != io.EOF {
+			t.Fatalf("expected io.EOF, got %+v", err)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		var first, second bytes.Buffer
+		if err := fs.WriteZip(&first); err != nil {
+			t.Fatalf("writing zip: %+v", err)
+		}
+
+		if err := fs.WriteZip(&second); err != nil {
+			t.Fatalf("writing zip: %+v", err)
+		}
+
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("expected identical archives")
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(first.Bytes()), int64(first.Len()))
+		if err != nil {
+			t.Fatalf("reading zip: %+v", err)
+		}
+
+		if len(zr.File) != len(expected) {
+			t.Fatalf("expected %d entries, got %d", len(expected), len(zr.File))
+		}
+
+		for i, e := range expected {
+			f := zr.File[i]
+			if f.Name != e.name || f.Mode() != e.mode || !f.Modified.Equal(modTime) {
+				t.Fatalf("expected %s %v %v, got %s %v %v", e.name, e.mode, modTime, f.Name, f.Mode(), f.Modified)
+			}
+
+			r, err := f.Open()
+			if err != nil {
+				t.Fatalf("opening %s: %+v", f.Name, err)
+			}
+
+			if b, _ := io.ReadAll(r); string(b) != e.data {
+				t.Fatalf("expected data %q for %s, got %q", e.data, e.name, b)
+			}
+			r.Close()
+		}
+	})
+}
+
+func TestFromArchive(t *testing.T) {
+	modTime := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	src := New()
+	src.Add("foo", 4, 0644, modTime, "1234")
+	src.Add("d/beta", 8, 0755, modTime, "98765432")
+	src.AddDir("e", os.ModeDir|0700, modTime)
+
+	var tarBuf, zipBuf bytes.Buffer
+	if err := src.WriteTar(&tarBuf); err != nil {
+		t.Fatalf("writing tar: %+v", err)
+	}
+
+	if err := src.WriteZip(&zipBuf); err != nil {
+		t.Fatalf("writing zip: %+v", err)
+	}
+
+	fromTar, err := NewFromTar(&tarBuf)
+	if err != nil {
+		t.Fatalf("reading tar: %+v", err)
+	}
+
+	fromZip, err := NewFromZip(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	if err != nil {
+		t.Fatalf("reading zip: %+v", err)
+	}
+
+	cases := []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{"/foo", 0644, "1234"},
+		{"/d", os.ModeDir | 0755, ""},
+		{"/d/beta", 0755, "98765432"},
+		{"/e", os.ModeDir | 0700, ""},
+	}
+
+	for i, fs := range []*FileSystem{fromTar, fromZip} {
+		for j, tc := range cases {
+			t.Run(fmt.Sprintf("case %d-%d", i, j), func(t *testing.T) {
+				f, err := fs.Open(tc.name)
+				if err != nil {
+					t.Fatalf("opening file: %+v", err)
+				}
+				defer f.Close()
+
+				stat, err := f.Stat()
+				if err != nil {
+					t.Fatalf("file stat: %+v", err)
+				}
+
+				if stat.Mode() != tc.mode || !stat.ModTime().Equal(modTime) {
+					t.Fatalf("expected %v %v, got %v %v", tc.mode, modTime, stat.Mode(), stat.ModTime())
+				}
+
+				if stat.IsDir() {
+					return
+				}
+
+				if _, err := f.Seek(2, io.SeekStart); err != nil {
+					t.Fatalf("seeking: %+v", err)
+				}
+
+				b, err := io.ReadAll(f)
+				if err != nil {
+					t.Fatalf("reading file: %+v", err)
+				}
+
+				if string(b) != tc.data[2:] {
+					t.Fatalf("expected data %s, got %s", tc.data[2:], b)
+				}
+			})
+		}
+	}
+
+	var evil bytes.Buffer
+	tw := tar.NewWriter(&evil)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+
+	if _, err := NewFromTar(&evil); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %+v", err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	modTime := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("compressed data"))
+	w.Close()
+
+	fs := New()
+	fs.Add("foo", 4, 0644, modTime, "1234")
+	fs.Add("bin/run.sh", 10, 0755, modTime, "#!/bin/sh\n")
+	fs.Add("sql/001.sql", 15, 0600, modTime, buf.String(), Gzipped())
+	fs.AddDir("sql", os.ModeDir|0700, modTime)
+
+	dir := t.TempDir()
+	if err := fs.Extract(dir); err != nil {
+		t.Fatalf("extracting: %+v", err)
+	}
+
+	cases := []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{"foo", 0644, "1234"},
+		{"bin", os.ModeDir | 0755, ""},
+		{"bin/run.sh", 0755, "#!/bin/sh\n"},
+		{"sql", os.ModeDir | 0700, ""},
+		{"sql/001.sql", 0600, "compressed data"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			name := filepath.Join(dir, filepath.FromSlash(tc.name))
+
+			stat, err := os.Stat(name)
+			if err != nil {
+				t.Fatalf("stat: %+v", err)
+			}
+
+			if stat.Mode() != tc.mode || !stat.ModTime().Equal(modTime) {
+				t.Fatalf("expected %v %v, got %v %v", tc.mode, modTime, stat.Mode(), stat.ModTime())
+			}
+
+			if stat.IsDir() {
+				return
+			}
+
+			if b, _ := os.ReadFile(name); string(b) != tc.data {
+				t.Fatalf("expected data %q, got %q", tc.data, b)
+			}
+		})
+	}
+
+	if err := fs.Extract(dir); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist, got %+v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "foo"), []byte("4321"), 0644)
+
+	if err := fs.Extract(dir, SkipExisting()); err != nil {
+		t.Fatalf("extracting: %+v", err)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "foo")); string(b) != "4321" {
+		t.Fatalf("expected existing data to be kept, got %q", b)
+	}
+
+	if err := fs.Extract(dir, SkipUnchanged()); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist for a changed file, got %+v", err)
+	}
+
+	// Unchanged files are left alone, keeping the modification time
+	later := modTime.Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "bin/run.sh"), later, later)
+
+	if err := fs.Extract(dir, SkipUnchanged(), Overwrite(), Atomic()); err != nil {
+		t.Fatalf("extracting: %+v", err)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "foo")); string(b) != "1234" {
+		t.Fatalf("expected data to be overwritten, got %q", b)
+	}
+
+	if stat, _ := os.Stat(filepath.Join(dir, "bin/run.sh")); !stat.ModTime().Equal(later) {
+		t.Fatalf("expected unchanged file to be skipped")
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %v", entries)
+	}
+
+	sub := t.TempDir()
+	if err := fs.Extract(sub, Subtree("/sql")); err != nil {
+		t.Fatalf("extracting subtree: %+v", err)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(sub, "001.sql")); string(b) != "compressed data" {
+		t.Fatalf("expected subtree data, got %q", b)
+	}
+
+	if err := fs.Extract(sub, Subtree("missing")); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	if err := fs.Extract(sub, Subtree("foo")); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %+v", err)
+	}
+}
+
+func TestPack(t *testing.T) {
+	modTime := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("compressed data"))
+	w.Close()
+
+	src := New()
+	src.Add("foo", 4, 0644, modTime, "1234", SHA256("abcd"), ContentType("text/plain"))
+	src.Add("d/alpha", 8, 0600, modTime, "98765432")
+	src.Add("d/beta", 8, 0644, modTime, "98765432")
+	src.Add("d/sub/gamma", 15, 0644, modTime, buf.String(), Gzipped())
+	src.Add("d-e", 4, 0644, modTime, "dash")
+	src.AddDir("empty", os.ModeDir|0700, modTime)
+	src.AddFingerprint("foo", "foo.abcd")
+
+	var pack bytes.Buffer
+	if err := src.WritePack(&pack); err != nil {
+		t.Fatalf("writing pack: %+v", err)
+	}
+
+	var again bytes.Buffer
+	src.WritePack(&again)
+	if !bytes.Equal(pack.Bytes(), again.Bytes()) {
+		t.Fatalf("expected identical packs")
+	}
+
+	if strings.Count(pack.String(), "98765432") != 1 {
+		t.Fatalf("expected identical data to be stored once")
+	}
+
+	fs, err := LoadPack(pack.String())
+	if err != nil {
+		t.Fatalf("loading pack: %+v", err)
+	}
+
+	if err := fstest.TestFS(fs.FS(), "foo", "foo.abcd", "d/alpha", "d/beta", "d/sub/gamma", "d-e", "empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		mode os.FileMode
+		data string
+	}{
+		{"/foo", 0644, "1234"},
+		{"/foo.abcd", 0644, "1234"},
+		{"/d/alpha", 0600, "98765432"},
+		{"/d/sub/gamma", 0644, "compressed data"},
+		{"/d-e", 0644, "dash"},
+		{"/empty", os.ModeDir | 0700, ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+
+			stat, _ := f.Stat()
+			if stat.Mode() != tc.mode || !stat.ModTime().Equal(modTime) {
+				t.Fatalf("expected %v %v, got %v %v", tc.mode, modTime, stat.Mode(), stat.ModTime())
+			}
+
+			if stat.IsDir() {
+				return
+			}
+
+			if b, _ := io.ReadAll(f); string(b) != tc.data {
+				t.Fatalf("expected data %q, got %q", tc.data, b)
+			}
+		})
+	}
+
+	if p := fs.AssetPath("/foo"); p != "/foo.abcd" {
+		t.Fatalf("expected asset path /foo.abcd, got %s", p)
+	}
+
+	if h, _ := fs.Hash("foo"); h != "abcd" {
+		t.Fatalf("expected hash abcd, got %s", h)
+	}
+
+	f, err := fs.Open("/d")
+	if err != nil {
+		t.Fatalf("opening dir: %+v", err)
+	}
+
+	files, _ := f.Readdir(-1)
+	if len(files) != 3 || files[0].Name() != "alpha" || files[2].Name() != "sub" {
+		t.Fatalf("expected alpha, beta and sub, got %v", files)
+	}
+
+	// Added files shadow packed ones, and are listed along with them
+	if err := fs.Add("d/alpha", 3, 0644, modTime, "new"); err != nil {
+		t.Fatalf("adding file: %+v", err)
+	}
+
+	fs.Add("d/delta", 5, 0644, modTime, "delta")
+
+	if b, _ := iofs.ReadFile(fs.FS(), "d/alpha"); string(b) != "new" {
+		t.Fatalf("expected added data, got %q", b)
+	}
+
+	entries, _ := iofs.ReadDir(fs.FS(), "d")
+	if len(entries) != 4 || entries[2].Name() != "delta" {
+		t.Fatalf("expected alpha, beta, delta and sub, got %v", entries)
+	}
+
+	if stat, _ := iofs.Stat(fs.FS(), "d"); stat.Mode() != os.ModeDir|0x1ed || !stat.ModTime().Equal(modTime) {
+		t.Fatalf("expected packed directory metadata, got %v %v", stat.Mode(), stat.ModTime())
+	}
+
+	if _, err := fs.Open("missing"); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	if _, err := LoadPack("not a pack"); err != ErrPackFormat {
+		t.Fatalf("expected ErrPackFormat, got %+v", err)
+	}
+
+	b := pack.Bytes()
+	b[len(packMagic)] = packVersion + 1
+	if _, err := LoadPack(string(b)); err != ErrPackVersion {
+		t.Fatalf("expected ErrPackVersion, got %+v", err)
+	}
+}
+
+func TestPackFile(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("compressed data"))
+	w.Close()
+
+	src := New()
+	src.Add("foo", 4, 0644, now, "1234")
+	src.Add("d/zeta", 15, 0644, now, buf.String(), Gzipped())
+
+	var pack bytes.Buffer
+	if err := src.WritePack(&pack); err != nil {
+		t.Fatalf("writing pack: %+v", err)
+	}
+
+	dir := t.TempDir()
+	standalone := filepath.Join(dir, "assets.pack")
+	appended := filepath.Join(dir, "app")
+	invalid := filepath.Join(dir, "invalid")
+
+	os.WriteFile(standalone, pack.Bytes(), 0644)
+	os.WriteFile(appended, append([]byte("\x7fELF executable data"), pack.Bytes()...), 0755)
+	os.WriteFile(invalid, []byte("\x7fELF executable data"), 0755)
+
+	for i, name := range []string{standalone, appended} {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			fs, err := OpenPack(name)
+			if err != nil {
+				t.Fatalf("opening pack: %+v", err)
+			}
+			defer fs.Close()
+
+			if err := fstest.TestFS(fs.FS(), "foo", "d/zeta"); err != nil {
+				t.Fatal(err)
+			}
+
+			// Compressed files are written back as they are stored
+			var repacked bytes.Buffer
+			if err := fs.WritePack(&repacked); err != nil {
+				t.Fatalf("writing pack: %+v", err)
+			}
+
+			if !bytes.Contains(repacked.Bytes(), buf.Bytes()) {
+				t.Fatalf("expected the compressed data in the written pack")
+			}
+
+			f, err := fs.Open("/d/zeta")
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+
+			if _, err := f.Seek(11, io.SeekStart); err != nil {
+				t.Fatalf("seeking: %+v", err)
+			}
+
+			if b, _ := io.ReadAll(f); string(b) != "data" {
+				t.Fatalf("expected data %q, got %q", "data", b)
+			}
+			f.Close()
+
+			if err := fs.Close(); err != nil {
+				t.Fatalf("closing: %+v", err)
+			}
+
+			if _, err := fs.Open("/foo"); err == nil {
+				t.Fatalf("expected an error opening a file of a closed pack")
+			}
+		})
+	}
+
+	if _, err := OpenPack(invalid); !errors.Is(err, ErrPackFormat) {
+		t.Fatalf("expected ErrPackFormat, got %+v", err)
+	}
+
+	if _, err := OpenPack(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	fs := New()
+	fs.Add("themes/light/style.css", 7, 0644, now, "body {}")
+	fs.AddSymlink("themes/dark", "light", now)
+	fs.AddSymlink("themes/default", "/themes/dark", now)
+	fs.AddSymlink("style.css", "themes/default/style.css", now)
+	fs.AddSymlink("loop", "loop2", now)
+	fs.AddSymlink("loop2", "loop", now)
+	fs.AddSymlink("escape", "../outside", now)
+	fs.AddSymlink("dangling", "missing", now)
+
+	cases := []struct {
+		name   string
+		exists bool
+		data   string
+	}{
+		{"/themes/dark/style.css", true, "body {}"},
+		{"/themes/default/style.css", true, "body {}"},
+		{"/style.css", true, "body {}"},
+		{"/themes/dark", true, ""},
+		{"/loop", false, ""},
+		{"/escape", false, ""},
+		{"/dangling", false, ""},
+		{"/dangling/file", false, ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if !tc.exists {
+				if !os.IsNotExist(errors.Cause(err)) {
+					t.Fatalf("expected ErrNotExist, got %+v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+
+			stat, _ := f.Stat()
+			if stat.IsDir() {
+				files, _ := f.Readdir(-1)
+				if len(files) != 1 || files[0].Name() != "style.css" {
+					t.Fatalf("expected the linked directory's files, got %v", files)
+				}
+				return
+			}
+
+			if b, _ := io.ReadAll(f); string(b) != tc.data {
+				t.Fatalf("expected data %q, got %q", tc.data, b)
+			}
+		})
+	}
+
+	stat, err := fs.Lstat("/themes/dark")
+	if err != nil {
+		t.Fatalf("lstat: %+v", err)
+	}
+
+	if stat.Mode() != os.ModeSymlink|0777 || stat.Size() != 5 {
+		t.Fatalf("expected a symlink, got %v %d", stat.Mode(), stat.Size())
+	}
+
+	if stat, _ := fs.Lstat("/themes/dark/style.css"); stat == nil || !stat.Mode().IsRegular() {
+		t.Fatalf("expected a regular file, got %v", stat)
+	}
+
+	if target, err := fs.Readlink("themes/default"); err != nil || target != "/themes/dark" {
+		t.Fatalf("expected target /themes/dark, got %s %+v", target, err)
+	}
+
+	if _, err := fs.Readlink("themes/light"); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %+v", err)
+	}
+
+	if _, err := fs.Readlink("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	linker, ok := fs.FS().(interface{ ReadLink(string) (string, error) })
+	if !ok {
+		t.Fatalf("expected the fs.FS to read links")
+	}
+
+	if target, err := linker.ReadLink("loop"); err != nil || target != "loop2" {
+		t.Fatalf("expected target loop2, got %s %+v", target, err)
+	}
+
+	themes, _ := iofs.Sub(fs.FS(), "themes")
+	if err := fstest.TestFS(themes, "light/style.css", "dark", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Links survive archives and packs
+	var tarBuf, zipBuf, packBuf bytes.Buffer
+	fs.WriteTar(&tarBuf)
+	fs.WriteZip(&zipBuf)
+	fs.WritePack(&packBuf)
+
+	fromTar, err := NewFromTar(&tarBuf)
+	if err != nil {
+		t.Fatalf("reading tar: %+v", err)
+	}
+
+	fromZip, err := NewFromZip(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	if err != nil {
+		t.Fatalf("reading zip: %+v", err)
+	}
+
+	fromPack, err := LoadPack(packBuf.String())
+	if err != nil {
+		t.Fatalf("loading pack: %+v", err)
+	}
+
+	for i, fs := range []*FileSystem{fromTar, fromZip, fromPack} {
+		if target, err := fs.Readlink("style.css"); err != nil || target != "themes/default/style.css" {
+			t.Fatalf("case %d: expected target themes/default/style.css, got %s %+v", i, target, err)
+		}
+
+		if b, err := iofs.ReadFile(fs.FS(), "style.css"); err != nil || string(b) != "body {}" {
+			t.Fatalf("case %d: expected data %q, got %q %+v", i, "body {}", b, err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := fs.Extract(dir); err != nil {
+		t.Fatalf("extracting: %+v", err)
+	}
+
+	if target, _ := os.Readlink(filepath.Join(dir, "themes", "default")); target != "dark" {
+		t.Fatalf("expected extracted target dark, got %s", target)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "style.css")); string(b) != "body {}" {
+		t.Fatalf("expected data through the extracted links, got %q", b)
+	}
+
+	if err := fs.Extract(dir, SkipUnchanged()); err != nil {
+		t.Fatalf("expected unchanged links to be skipped, got %+v", err)
+	}
+
+	// Links of a fallback directory aren't followed either
+	for _, fallback := range []http.FileSystem{Dir(dir), http.Dir(dir)} {
+		fs := New()
+		fs.FallbackFS = fallback
+
+		if stat, err := fs.Lstat("/themes/dark"); err != nil || stat.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("%T: expected a symlink, got %v %+v", fallback, stat, err)
+		}
+
+		if stat, err := fs.Lstat("/themes/dark/style.css"); err != nil || !stat.Mode().IsRegular() {
+			t.Fatalf("%T: expected a regular file, got %v %+v", fallback, stat, err)
+		}
+
+		_, err := fs.Lstat("/themes/missing")
+		if pe, ok := err.(*os.PathError); !ok || pe.Op != "lstat" || pe.Path != "/themes/missing" || !os.IsNotExist(pe.Err) {
+			t.Fatalf("%T: expected an lstat ErrNotExist, got %+v", fallback, err)
+		}
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	packed := New()
+	packed.Add("fonts/Sans.ttf", 6, 0644, now, "packed")
+	packed.Add("Media/Logo.png", 6, 0644, now, "packed")
+	packed.Add("Readme", 6, 0644, now, "packed")
+
+	var buf bytes.Buffer
+	if err := packed.WritePack(&buf); err != nil {
+		t.Fatalf("writing pack: %+v", err)
+	}
+
+	fs, err := LoadPack(buf.String())
+	if err != nil {
+		t.Fatalf("loading pack: %+v", err)
+	}
+
+	// Added before the lookup is case-insensitive, so the names don't conflict
+	fs.Add("docs/README", 6, 0644, now, "upper")
+	fs.Add("docs/readme", 6, 0644, now, "lower")
+	fs.Add("docs/ReadMe", 5, 0644, now, "mixed")
+
+	fs.CaseInsensitive = true
+	fs.Add("images/logo.png", 4, 0644, now, "logo")
+	fs.Add("Images/Icons/Ünïcode.svg", 3, 0644, now, "svg")
+
+	cases := []struct {
+		name   string
+		exists bool
+		data   string
+	}{
+		{"/images/logo.png", true, "logo"},
+		{"/Images/Logo.PNG", true, "logo"},
+		{"/IMAGES/icons/üNÏCODE.SVG", true, "svg"},
+		{"/docs/readme", true, "lower"},
+		{"/docs/ReadMe", true, "mixed"},
+		{"/docs/README", true, "upper"},
+		{"/docs/Readme", true, "upper"},
+		{"/FONTS/sans.TTF", true, "packed"},
+		{"/images/logo.gif", false, ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			f, err := fs.Open(tc.name)
+			if !tc.exists {
+				if !os.IsNotExist(errors.Cause(err)) {
+					t.Fatalf("expected ErrNotExist, got %+v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("opening file: %+v", err)
+			}
+
+			if b, _ := io.ReadAll(f); string(b) != tc.data {
+				t.Fatalf("expected data %q, got %q", tc.data, b)
+			}
+		})
+	}
+
+	if err := fs.Add("IMAGES/LOGO.png", 4, 0644, now, "logo"); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist, got %+v", err)
+	}
+
+	if err := fs.Add("fonts/SANS.ttf", 4, 0644, now, "sans"); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist for a packed file, got %+v", err)
+	}
+
+	// Packed files may still be shadowed by their exact names
+	if err := fs.Add("fonts/Sans.ttf", 5, 0644, now, "added"); err != nil {
+		t.Fatalf("shadowing packed file: %+v", err)
+	}
+
+	if err := fs.Replace("IMAGES/Logo.png", 3, 0644, now, "new"); err != nil {
+		t.Fatalf("replacing file: %+v", err)
+	}
+
+	f, err := fs.Open("images")
+	if err != nil {
+		t.Fatalf("opening dir: %+v", err)
+	}
+
+	files, _ := f.Readdir(-1)
+	if len(files) != 2 || files[0].Name() != "Icons" || files[1].Name() != "logo.png" {
+		t.Fatalf("expected the existing names to be kept, got %v", files)
+	}
+
+	if err := fs.Rename("images/logo.png", "images/Logo.png", false); err != nil {
+		t.Fatalf("renaming by case: %+v", err)
+	}
+
+	if err := fs.Rename("images", "IMAGES/icons/images", false); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %+v", err)
+	}
+
+	if err := fs.Remove("/IMAGES/ICONS", true); err != nil {
+		t.Fatalf("removing dir: %+v", err)
+	}
+
+	if b, err := iofs.ReadFile(fs.FS(), "images/Logo.png"); err != nil || string(b) != "new" {
+		t.Fatalf("expected data %q, got %q %+v", "new", b, err)
+	}
+
+	if _, err := fs.Open("/images/icons"); !os.IsNotExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+
+	// Parents are matched to packed directories as well
+	if err := fs.Add("MEDIA/logo.PNG", 5, 0644, now, "added"); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist for a packed file, got %+v", err)
+	}
+
+	if err := fs.Add("MEDIA/icon.svg", 4, 0644, now, "icon"); err != nil {
+		t.Fatalf("adding file: %+v", err)
+	}
+
+	if err := fs.Add("README/file", 4, 0644, now, "file"); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist for a packed file parent, got %+v", err)
+	}
+
+	root, _ := fs.Open("/")
+	files, _ = root.Readdir(-1)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	if strings.Join(names, " ") != "Media Readme docs fonts images" {
+		t.Fatalf("expected the packed directory to be extended, got %v", names)
+	}
+
+	if b, err := iofs.ReadFile(fs.FS(), "media/LOGO.png"); err != nil || string(b) != "packed" {
+		t.Fatalf("expected data %q, got %q %+v", "packed", b, err)
+	}
+
+	// Implicit directories are updated regardless of case
+	fs.Add("assets/app.js", 2, 0644, now, "js")
+	if err := fs.AddDir("ASSETS", 0700, now); err != nil {
+		t.Fatalf("adding implicit dir: %+v", err)
+	}
+
+	if stat, err := fs.Lstat("assets"); err != nil || stat.Name() != "assets" || stat.Mode() != os.ModeDir|0700 {
+		t.Fatalf("expected the implicit dir to be updated, got %v %+v", stat, err)
+	}
+
+	if err := fs.AddDir("Assets", 0700, now); !os.IsExist(errors.Cause(err)) {
+		t.Fatalf("expected ErrExist, got %+v", err)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	fs := New()
+	fs.Add("foo", 4, 0644, now, "1234")
+	fs.Seal()
+
+	if !fs.Sealed() {
+		t.Fatalf("expected a sealed filesystem")
+	}
+
+	for i, err := range []error{
+		fs.Add("bar", 4, 0644, now, "1234"),
+		fs.AddDir("dir", 0755, now),
+		fs.AddSymlink("link", "foo", now),
+		fs.Replace("foo", 4, 0644, now, "4321"),
+		fs.Remove("foo", false),
+		fs.Rename("foo", "bar", false),
+		fs.AddFingerprint("foo", "foo.1234"),
+	} {
+		if !errors.Is(err, ErrSealed) {
+			t.Fatalf("case %d: expected ErrSealed, got %+v", i, err)
+		}
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+
+			for j := 0; j < 100; j++ {
+				f, err := fs.Open("/foo")
+				if err != nil {
+					t.Errorf("opening file: %+v", err)
+					return
+				}
+
+				if b, _ := io.ReadAll(f); string(b) != "1234" {
+					t.Errorf("expected data %q, got %q", "1234", b)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = fmt.Sprintf("/assets/%d/file.css", i)
+	}
+
+	for _, sealed := range []bool{false, true} {
+		fs := New()
+		for _, name := range names {
+			fs.Add(name, 4, 0644, now, "body")
+		}
+
+		name := "locked"
+		if sealed {
+			fs.Seal()
+			name = "sealed"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, err := fs.Open(names[i%len(names)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkAddSiblings(b *testing.B) {
+	names := make([]string, 20000)
+	for i := range names {
+		names[i] = fmt.Sprintf("icons/%05d.svg", i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs := New()
+		for j, name := range names {
+			if err := fs.Add(name, 4, 0644, now.Add(time.Duration(j)), "<svg"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkOpenDeep(b *testing.B) {
+	name := "/" + strings.Repeat("very/deeply/nested/", 10) + "file.css"
+
+	fs := New()
+	fs.Add(name, 4, 0644, now, "body")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.Open(name); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReaddirLarge(b *testing.B) {
+	fs := New()
+	for i := 0; i < 10000; i++ {
+		fs.Add(fmt.Sprintf("icons/%05d.svg", i), 4, 0644, now, "<svg")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := fs.Open("/icons")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if files, _ := f.Readdir(-1); len(files) != 10000 {
+			b.Fatalf("expected 10000 files, got %d", len(files))
+		}
+	}
+}
+
+func BenchmarkReaddirPacked(b *testing.B) {
+	src := New()
+	for i := 0; i < 10000; i++ {
+		src.Add(fmt.Sprintf("icons/%05d.svg", i), 4, 0644, now, "<svg")
+	}
+
+	var pack bytes.Buffer
+	if err := src.WritePack(&pack); err != nil {
+		b.Fatal(err)
+	}
+
+	fs, err := LoadPack(pack.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := fs.Open("/icons")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if files, _ := f.Readdir(-1); len(files) != 10000 {
+			b.Fatalf("expected 10000 files, got %d", len(files))
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	fs := New()
+	fs.Add("a/b/c/file", 4, 0644, now, "1234")
+	fs.Add("a/b/other", 4, 0644, now, "1234")
+	fs.AddDir("a/d", 0755, now)
+	fs.AddSymlink("a/link", "b", now)
+	fs.AddFingerprint("a/b/other", "a/b/other.1234")
+
+	readdir := func(name string) []string {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("opening %s: %+v", name, err)
+		}
+
+		files, _ := f.Readdir(-1)
+
+		names := make([]string, 0, len(files))
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+
+		return names
+	}
+
+	if names := fmt.Sprint(readdir("a/b")); names != "[c other other.1234]" {
+		t.Fatalf("expected the listing of a/b, got %s", names)
+	}
+
+	fs.Replace("a/b/other", 5, 0644, now.Add(time.Hour), "12345")
+	fs.Rename("a/b/c", "a/d/e/c", true)
+	fs.Remove("a/b/other.1234", false)
+	fs.Add("a/b/new", 4, 0644, now, "1234")
+
+	if names := fmt.Sprint(readdir("a/b")); names != "[new other]" {
+		t.Fatalf("expected the cached listing to be updated, got %s", names)
+	}
+
+	if files, _ := iofs.ReadDir(fs.FS(), "a/d/e"); len(files) != 1 || files[0].Name() != "c" {
+		t.Fatalf("expected the renamed directory, got %v", files)
+	}
+
+	if stat, _ := fs.Lstat("a"); !stat.ModTime().Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the modification time of the newest file, got %v", stat.ModTime())
+	}
+
+	expected := map[string]*node{".": fs.root}
+	fs.walk(".", fs.root, func(name string, n *node) error {
+		expected[name] = n
+		return nil
+	})
+
+	if len(fs.paths) != len(expected) {
+		t.Fatalf("expected %d indexed paths, got %d", len(expected), len(fs.paths))
+	}
+
+	for name, n := range expected {
+		if fs.paths[name] != n {
+			t.Fatalf("expected %s to be indexed", name)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	fs := New()
+	fs.Add("views/index.html", 5, 0644, now, "index")
+	fs.Add("views/admin/users.html", 5, 0644, now, "users")
+	fs.Add("views/admin/skipped/page.html", 4, 0644, now, "page")
+	fs.Add("views/partials/footer.html", 6, 0644, now, "footer")
+	fs.Add("views/partials/header.html", 6, 0644, now, "header")
+	fs.Add("static/app.css", 4, 0644, now, "body")
+	fs.AddSymlink("views/theme", "/static", now)
+
+	var names []string
+	err := fs.Walk("/views", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		names = append(names, name)
+
+		switch name {
+		case "/views/admin/skipped":
+			return filepath.SkipDir
+		case "/views/partials/footer.html":
+			return filepath.SkipDir
+		}
+
+		if !info.IsDir() && name == "/views/index.html" && info.Size() != 5 {
+			t.Fatalf("expected size 5, got %d", info.Size())
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking: %+v", err)
+	}
+
+	expected := []string{
+		"/views", "/views/admin", "/views/admin/skipped", "/views/admin/users.html",
+		"/views/index.html", "/views/partials", "/views/partials/footer.html", "/views/theme",
+	}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+
+	errStop := errors.New("stop")
+	if err := fs.Walk(".", func(string, os.FileInfo, error) error { return errStop }); err != errStop {
+		t.Fatalf("expected the error of the walk function, got %+v", err)
+	}
+
+	err = fs.Walk("missing", func(name string, info os.FileInfo, err error) error {
+		if info != nil || !os.IsNotExist(errors.Cause(err)) {
+			t.Fatalf("expected ErrNotExist, got %v %+v", info, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the error to be handled, got %+v", err)
+	}
+
+	// Adding files while walking doesn't deadlock
+	err = fs.Walk("static", func(name string, info os.FileInfo, err error) error {
+		if !info.IsDir() {
+			return fs.Add(name+".map", 2, 0644, now, "{}")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("adding files while walking: %+v", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	fs := New()
+	fs.Add("views/index.html", 5, 0644, now, "index")
+	fs.Add("views/index.txt", 5, 0644, now, "index")
+	fs.Add("views/admin/users.html", 5, 0644, now, "users")
+	fs.Add("views/admin/users/list.html", 4, 0644, now, "list")
+	fs.Add("static/theme/style.css", 4, 0644, now, "body")
+	fs.AddSymlink("views/theme", "/static/theme", now)
+
+	cases := []struct {
+		pattern string
+		matches []string
+	}{
+		{"/views/*.html", []string{"/views/index.html"}},
+		{"views/*", []string{"views/admin", "views/index.html", "views/index.txt", "views/theme"}},
+		{"/views/**/*.html", []string{"/views/admin/users.html", "/views/admin/users/list.html", "/views/index.html"}},
+		{"**/*.css", []string{"static/theme/style.css"}},
+		{"views/theme/*.css", []string{"views/theme/style.css"}},
+		{"views/**", []string{"views", "views/admin", "views/admin/users", "views/admin/users.html", "views/admin/users/list.html", "views/index.html", "views/index.txt", "views/theme"}},
+		{"/views/admin/users.html", []string{"/views/admin/users.html"}},
+		{"/views/[j-s]*", nil},
+		{"/missing/**", nil},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			matches, err := fs.Glob(tc.pattern)
+			if err != nil {
+				t.Fatalf("globbing: %+v", err)
+			}
+
+			if fmt.Sprint(matches) != fmt.Sprint(tc.matches) {
+				t.Fatalf("expected %v, got %v", tc.matches, matches)
+			}
+		})
+	}
+
+	if _, err := fs.Glob("views/[a-"); err != path.ErrBadPattern {
+		t.Fatalf("expected ErrBadPattern, got %+v", err)
+	}
+}
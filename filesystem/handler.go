@@ -0,0 +1,252 @@
+package filesystem
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A Handler serves HTTP requests with the contents of a FileSystem. Files
+// stored gzip compressed are sent as they are to clients that accept the gzip
+// content encoding, and are decompressed for all others, in which case range
+// requests are supported as well.
+//
+// Files added with a recorded SHA256 sum are served with a strong ETag
+// header, allowing clients to revalidate them with If-None-Match requests.
+// Content types recorded with the ContentType option take precedence over
+// ones detected from the file's extension or data. Fingerprinted aliases are
+// served with a Cache-Control header that allows caching them for a year.
+//
+// Directories, and files that haven't been added to the filesystem, are
+// handled by an http.FileServer, unless a Listing function is set.
+type Handler struct {
+	// Listing, when set, is used to serve directories without an
+	// index.html file, instead of the http.FileServer's listing. Use
+	// NotFoundListing to hide such directories altogether.
+	Listing ListingFunc
+
+	fs     *FileSystem
+	server http.Handler
+}
+
+// NewHandler creates a Handler serving the files of the given FileSystem.
+func NewHandler(fs *FileSystem) *Handler {
+	return &Handler{fs: fs, server: http.FileServer(fs)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upath := r.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+
+	name := path.Clean(upath)
+	if strings.HasSuffix(upath, "/") && name != "/" {
+		name += "/"
+	}
+
+	// Added index.html files are served under their own names as well, with
+	// the same headers, while the file server redirects requests for others
+	if strings.HasSuffix(name, "/index.html") {
+		if !h.serveFile(w, r, name) {
+			h.server.ServeHTTP(w, r)
+		}
+		return
+	}
+
+	dir := strings.HasSuffix(name, "/")
+	if dir {
+		name += "index.html"
+	}
+
+	if h.serveFile(w, r, name) {
+		return
+	}
+
+	if dir && h.Listing != nil && h.serveListing(w, r, path.Dir(name)) {
+		return
+	}
+
+	h.server.ServeHTTP(w, r)
+}
+
+// serveFile serves the named file, if it has been added to the filesystem,
+// and reports whether it did so.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	locked := h.fs.rlock()
+	n, ok := h.fs.lookup(clean(name))
+	ok = ok && !n.stat.IsDir()
+	var e entry
+	if ok {
+		e = entry{n.stat, n.data, n.gzip, n.stream, n.hash, n.ctype, n.immutable}
+	}
+	if locked {
+		h.fs.mutex.RUnlock()
+	}
+
+	if ok {
+		h.serve(w, r, name, e)
+	}
+
+	return ok
+}
+
+// entry holds a snapshot of a file node's content.
+type entry struct {
+	stat      info
+	data      string
+	gzip      bool
+	stream    func() (io.ReadCloser, error)
+	hash      string
+	ctype     string
+	immutable bool
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, name string, e entry) {
+	if _, ok := w.Header()["Cache-Control"]; e.immutable && !ok {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+
+	if _, ok := w.Header()["Content-Type"]; e.ctype != "" && !ok {
+		w.Header().Set("Content-Type", e.ctype)
+	}
+
+	if !e.gzip {
+		setETag(w, e.hash, "")
+		http.ServeContent(w, r, name, e.stat.ModTime(), e.open(name))
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var stored io.ReadSeeker
+	if acceptsGzip(r) {
+		var closer io.Closer
+		stored, closer = e.stored()
+		if closer != nil {
+			defer closer.Close()
+		}
+	}
+
+	if stored == nil {
+		setETag(w, e.hash, "")
+		http.ServeContent(w, r, name, e.stat.ModTime(), e.open(name))
+		return
+	}
+
+	// The encoded representation needs an ETag of its own
+	setETag(w, e.hash, "-gzip")
+
+	if _, ok := w.Header()["Content-Type"]; !ok {
+		w.Header().Set("Content-Type", contentType(name, e))
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+
+	// Ranges are only supported for the identity encoding
+	if r.Header.Get("Range") != "" {
+		r = r.Clone(r.Context())
+		r.Header.Del("Range")
+	}
+
+	http.ServeContent(noRanges{w}, r, name, e.stat.ModTime(), stored)
+}
+
+// noRanges replaces the Accept-Ranges header that http.ServeContent sets with
+// one stating that ranges aren't supported.
+type noRanges struct {
+	http.ResponseWriter
+}
+
+func (w noRanges) WriteHeader(code int) {
+	w.Header().Set("Accept-Ranges", "none")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// open returns an http.File with the uncompressed data of the entry.
+func (e entry) open(name string) http.File {
+	n := node{stat: e.stat, data: e.data, gzip: e.gzip, stream: e.stream}
+	return n.open(name)
+}
+
+// stored returns the entry's data as it is stored, along with the stream it
+// is read from, if any, which has to be closed. The returned data is nil if
+// the stream can't be seeked.
+func (e entry) stored() (io.ReadSeeker, io.Closer) {
+	if e.stream == nil {
+		return strings.NewReader(e.data), nil
+	}
+
+	r, err := e.stream()
+	if err != nil {
+		return nil, nil
+	}
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, r
+	}
+	r.Close()
+
+	return nil, nil
+}
+
+// setETag sets a strong ETag header from the hash, if there is one, and
+// the response doesn't already have an ETag.
+func setETag(w http.ResponseWriter, hash, suffix string) {
+	if _, ok := w.Header()["Etag"]; hash != "" && !ok {
+		w.Header().Set("Etag", `"`+hash+suffix+`"`)
+	}
+}
+
+// contentType returns the recorded MIME type of the file, or one based on its
+// extension, or by sniffing its uncompressed data.
+func contentType(name string, e entry) string {
+	if e.ctype != "" {
+		return e.ctype
+	}
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+
+	f := e.open(name)
+	defer f.Close()
+
+	b := make([]byte, 512)
+	n, _ := io.ReadFull(f, b)
+
+	return http.DetectContentType(b[:n])
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows a
+// gzip encoded response.
+func acceptsGzip(r *http.Request) bool {
+	wildcard := 0.0
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			var params string
+			if i := strings.IndexByte(coding, ';'); i != -1 {
+				coding, params = coding[:i], coding[i+1:]
+			}
+			coding = strings.ToLower(strings.TrimSpace(coding))
+
+			q := 1.0
+			if kv := strings.SplitN(params, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = f
+				}
+			}
+
+			switch coding {
+			case "gzip", "x-gzip":
+				return q > 0
+			case "*":
+				wildcard = q
+			}
+		}
+	}
+
+	return wildcard > 0
+}
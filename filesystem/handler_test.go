@@ -0,0 +1,413 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	data := strings.Repeat("body { color: red; }\n", 100)
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	gz := buf.String()
+
+	fs := New()
+	fs.Add("style.css", int64(len(data)), 0644, now, gz, Gzipped())
+	fs.Add("plain.txt", 10, 0644, now, "0123456789")
+	fs.Add("d/index.html", 14, 0644, now, "<html></html>\n")
+
+	// Files of a pack file are streamed, and served the same way
+	var pack bytes.Buffer
+	if err := fs.WritePack(&pack); err != nil {
+		t.Fatalf("writing pack: %+v", err)
+	}
+
+	name := filepath.Join(t.TempDir(), "assets.pack")
+	if err := os.WriteFile(name, pack.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	packed, err := OpenPack(name)
+	if err != nil {
+		t.Fatalf("opening pack: %+v", err)
+	}
+	defer packed.Close()
+
+	handlers := []*Handler{NewHandler(fs), NewHandler(packed)}
+
+	cases := []struct {
+		path     string
+		encoding string
+		rng      string
+		code     int
+		body     string
+		ctype    string
+		gzip     bool
+		vary     bool
+	}{
+		{"/style.css", "gzip, deflate", "", http.StatusOK, gz, "text/css; charset=utf-8", true, true},
+		{"/style.css", "deflate, gzip;q=0.5", "", http.StatusOK, gz, "text/css; charset=utf-8", true, true},
+		{"/style.css", "*", "", http.StatusOK, gz, "text/css; charset=utf-8", true, true},
+		{"/style.css", "gzip;q=0, *", "", http.StatusOK, data, "text/css; charset=utf-8", false, true},
+		{"/style.css", "", "", http.StatusOK, data, "text/css; charset=utf-8", false, true},
+		{"/style.css", "", "bytes=21-41", http.StatusPartialContent, data[21:42], "text/css; charset=utf-8", false, true},
+		{"/style.css", "gzip", "bytes=21-41", http.StatusOK, gz, "text/css; charset=utf-8", true, true},
+		{"/plain.txt", "gzip", "", http.StatusOK, "0123456789", "text/plain; charset=utf-8", false, false},
+		{"/plain.txt", "gzip", "bytes=2-4", http.StatusPartialContent, "234", "text/plain; charset=utf-8", false, false},
+		{"/d/", "", "", http.StatusOK, "<html></html>\n", "text/html; charset=utf-8", false, false},
+		{"/d/index.html", "", "", http.StatusOK, "<html></html>\n", "text/html; charset=utf-8", false, false},
+		{"/d", "", "", http.StatusMovedPermanently, "", "", false, false},
+		{"/e/index.html", "", "", http.StatusMovedPermanently, "", "", false, false},
+		{"/missing", "", "", http.StatusNotFound, "", "", false, false},
+	}
+
+	for i, tc := range cases {
+		for j, h := range handlers {
+			t.Run(fmt.Sprintf("case %d/%d", i, j), func(t *testing.T) {
+				r := httptest.NewRequest("GET", tc.path, nil)
+				if tc.encoding != "" {
+					r.Header.Set("Accept-Encoding", tc.encoding)
+				}
+				if tc.rng != "" {
+					r.Header.Set("Range", tc.rng)
+				}
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+
+				if rec.Code != tc.code {
+					t.Fatalf("expected code %d, got %d", tc.code, rec.Code)
+				}
+
+				if tc.code >= 300 {
+					return
+				}
+
+				if rec.Body.String() != tc.body {
+					t.Fatalf("expected body %q, got %q", tc.body, rec.Body.String())
+				}
+
+				if ctype := rec.Header().Get("Content-Type"); ctype != tc.ctype {
+					t.Fatalf("expected content type %s, got %s", tc.ctype, ctype)
+				}
+
+				if gzip := rec.Header().Get("Content-Encoding") == "gzip"; gzip != tc.gzip {
+					t.Fatalf("expected gzip encoding: %v, got %v", tc.gzip, gzip)
+				}
+
+				if vary := rec.Header().Get("Vary") == "Accept-Encoding"; vary != tc.vary {
+					t.Fatalf("expected vary header: %v, got %v", tc.vary, vary)
+				}
+
+				ranges := "bytes"
+				if tc.gzip {
+					ranges = "none"
+				}
+
+				if ar := rec.Header().Get("Accept-Ranges"); ar != ranges {
+					t.Fatalf("expected accept ranges %s, got %s", ranges, ar)
+				}
+			})
+		}
+	}
+}
+
+func TestHandlerETag(t *testing.T) {
+	data := strings.Repeat("console.log('test');\n", 100)
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+
+	fs := New()
+	fs.Add("app.js", int64(len(data)), 0644, now, buf.String(), Gzipped(), SHA256(sum))
+	fs.Add("plain.js", int64(len(data)), 0644, now, data, SHA256(sum))
+	fs.Add("unhashed.js", int64(len(data)), 0644, now, data)
+	fs.Add("d/index.html", int64(len(data)), 0644, now, buf.String(), Gzipped(), SHA256(sum))
+
+	h := NewHandler(fs)
+
+	cases := []struct {
+		path        string
+		encoding    string
+		ifNoneMatch string
+		code        int
+		etag        string
+	}{
+		{"/app.js", "gzip", "", http.StatusOK, `"` + sum + `-gzip"`},
+		{"/app.js", "", "", http.StatusOK, `"` + sum + `"`},
+		{"/app.js", "gzip", `"` + sum + `-gzip"`, http.StatusNotModified, `"` + sum + `-gzip"`},
+		{"/app.js", "gzip", `"` + sum + `"`, http.StatusOK, `"` + sum + `-gzip"`},
+		{"/app.js", "", `"other", "` + sum + `"`, http.StatusNotModified, `"` + sum + `"`},
+		{"/plain.js", "gzip", `"` + sum + `"`, http.StatusNotModified, `"` + sum + `"`},
+		{"/plain.js", "", `*`, http.StatusNotModified, `"` + sum + `"`},
+		{"/unhashed.js", "", `"` + sum + `"`, http.StatusOK, ""},
+		{"/d/", "gzip", "", http.StatusOK, `"` + sum + `-gzip"`},
+		{"/d/index.html", "gzip", "", http.StatusOK, `"` + sum + `-gzip"`},
+		{"/d/index.html", "", `"` + sum + `"`, http.StatusNotModified, `"` + sum + `"`},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.path, nil)
+			if tc.encoding != "" {
+				r.Header.Set("Accept-Encoding", tc.encoding)
+			}
+			if tc.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected code %d, got %d", tc.code, rec.Code)
+			}
+
+			if etag := rec.Header().Get("Etag"); etag != tc.etag {
+				t.Fatalf("expected etag %s, got %s", tc.etag, etag)
+			}
+		})
+	}
+}
+
+func TestHandlerFingerprint(t *testing.T) {
+	fs := New()
+	fs.Add("app.js", 10, 0644, now, "0123456789")
+	fs.AddFingerprint("app.js", "app.3f9a1c2b.js")
+
+	h := NewHandler(fs)
+
+	cases := []struct {
+		path  string
+		cache string
+	}{
+		{"/app.js", ""},
+		{"/app.3f9a1c2b.js", "public, max-age=31536000, immutable"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+			if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+				t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+			}
+
+			if cache := rec.Header().Get("Cache-Control"); cache != tc.cache {
+				t.Fatalf("expected cache control %q, got %q", tc.cache, cache)
+			}
+		})
+	}
+}
+
+func TestHandlerTraversal(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		filepath.Join(tmp, "outside"): "outside",
+		filepath.Join(root, "inside"): "inside",
+	} {
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join("..", "outside"), filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	fs := New()
+	fs.FallbackFS = Dir(root)
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/inside", http.StatusOK},
+		{"/escape", http.StatusForbidden},
+		{"/missing", http.StatusNotFound},
+	}
+
+	for i, tc := range cases {
+		for j, h := range []http.Handler{NewHandler(fs), http.FileServer(fs)} {
+			t.Run(fmt.Sprintf("case %d/%d", i, j), func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+				if rec.Code != tc.code {
+					t.Fatalf("expected code %d, got %d", tc.code, rec.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestHandlerContentType(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("# Title\n"))
+	w.Close()
+
+	fs := New()
+	fs.Add("README", 8, 0644, now, "# Title\n", ContentType("text/markdown"))
+	fs.Add("data.json", 2, 0644, now, "{}", ContentType("application/vnd.api+json"))
+	fs.Add("NOTES", 8, 0644, now, buf.String(), Gzipped(), ContentType("text/markdown"))
+	fs.Add("plain", 8, 0644, now, "# Title\n")
+
+	h := NewHandler(fs)
+
+	cases := []struct {
+		path     string
+		encoding string
+		ctype    string
+	}{
+		{"/README", "", "text/markdown"},
+		{"/data.json", "", "application/vnd.api+json"},
+		{"/NOTES", "gzip", "text/markdown"},
+		{"/NOTES", "", "text/markdown"},
+		{"/plain", "", "text/plain; charset=utf-8"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.path, nil)
+			if tc.encoding != "" {
+				r.Header.Set("Accept-Encoding", tc.encoding)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			if ctype := rec.Header().Get("Content-Type"); ctype != tc.ctype {
+				t.Fatalf("expected content type %s, got %s", tc.ctype, ctype)
+			}
+		})
+	}
+}
+
+func TestSPAHandler(t *testing.T) {
+	fs := New()
+	fs.Add("index.html", 14, 0644, now, "<html></html>\n")
+	fs.Add("static/app.js", 10, 0644, now, "0123456789")
+	fs.AddFingerprint("static/app.js", "static/app.3f9a1c2b.js")
+	fs.Add("static/img/logo.png", 4, 0644, now, "\x89PNG")
+
+	h := NewSPAHandler(fs)
+	h.Exclude = []string{"/static/", "api"}
+
+	cases := []struct {
+		path  string
+		code  int
+		body  string
+		cache string
+	}{
+		{"/", http.StatusOK, "<html></html>\n", "no-cache"},
+		{"/users/42", http.StatusOK, "<html></html>\n", "no-cache"},
+		{"/static", http.StatusNotFound, "", ""},
+		{"/static/img", http.StatusNotFound, "", ""},
+		{"/statics/page", http.StatusOK, "<html></html>\n", "no-cache"},
+		{"/static/missing.js", http.StatusNotFound, "", ""},
+		{"/api/users", http.StatusNotFound, "", ""},
+		{"/static/app.js", http.StatusOK, "0123456789", ""},
+		{"/static/app.3f9a1c2b.js", http.StatusOK, "0123456789", "public, max-age=31536000, immutable"},
+		{"/index.html", http.StatusOK, "<html></html>\n", "no-cache"},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected code %d, got %d", tc.code, rec.Code)
+			}
+
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			if rec.Body.String() != tc.body {
+				t.Fatalf("expected body %q, got %q", tc.body, rec.Body.String())
+			}
+
+			if cache := rec.Header().Get("Cache-Control"); cache != tc.cache {
+				t.Fatalf("expected cache control %q, got %q", tc.cache, cache)
+			}
+		})
+	}
+
+	h = NewSPAHandler(New())
+	h.Index = "missing.html"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/page", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestListing(t *testing.T) {
+	fs := New()
+	fs.Add("d/beta", 4, 0644, now, "beta")
+	fs.Add("d/alpha", 5, 0644, now, "alpha")
+	fs.Add("i/index.html", 14, 0644, now, "<html></html>\n")
+
+	tmpl := template.Must(template.New("listing").Parse(
+		`{{ .Name }}:{{ range .Files }} {{ .Name }}{{ end }}`,
+	))
+
+	cases := []struct {
+		handler http.Handler
+		path    string
+		code    int
+		body    string
+	}{
+		{http.FileServer(fs), "/d/", http.StatusOK, ""},
+		{http.FileServer(NoListing(fs)), "/d/", http.StatusNotFound, ""},
+		{http.FileServer(NoListing(fs)), "/", http.StatusNotFound, ""},
+		{http.FileServer(NoListing(fs)), "/i/", http.StatusOK, "<html></html>\n"},
+		{http.FileServer(NoListing(fs)), "/d/alpha", http.StatusOK, "alpha"},
+		{NewHandler(fs), "/d/", http.StatusOK, ""},
+		{&Handler{Listing: NotFoundListing, fs: fs, server: http.FileServer(fs)}, "/d/", http.StatusNotFound, ""},
+		{&Handler{Listing: NotFoundListing, fs: fs, server: http.FileServer(fs)}, "/i/", http.StatusOK, "<html></html>\n"},
+		{&Handler{Listing: TemplateListing(tmpl), fs: fs, server: http.FileServer(fs)}, "/d/", http.StatusOK, "/d: alpha beta"},
+		{&Handler{Listing: TemplateListing(tmpl), fs: fs, server: http.FileServer(fs)}, "/", http.StatusOK, "/: d i"},
+		{&Handler{Listing: TemplateListing(tmpl), fs: fs, server: http.FileServer(fs)}, "/d", http.StatusMovedPermanently, ""},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected code %d, got %d", tc.code, rec.Code)
+			}
+
+			if tc.body != "" && rec.Body.String() != tc.body {
+				t.Fatalf("expected body %q, got %q", tc.body, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// SHA256 records the hex encoded SHA-256 sum of the uncompressed data of an
+// added file, as computed by the embed command. It is returned by Hash, and
+// used by a Handler to produce ETag headers.
+func SHA256(sum string) Option {
+	return func(n *node) {
+		n.hash = sum
+	}
+}
+
+// Hash returns the hex encoded SHA-256 sum of the named file's uncompressed
+// data. If the sum wasn't recorded when the file was added, it is computed
+// on every call.
+func (fs *FileSystem) Hash(name string) (string, error) {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	n, ok := fs.lookup(clean(name))
+	if !ok {
+		return "", &os.PathError{Op: "hash", Path: name, Err: os.ErrNotExist}
+	}
+
+	if n.stat.IsDir() {
+		return "", &os.PathError{Op: "hash", Path: name, Err: os.ErrInvalid}
+	}
+
+	sum, err := n.sum(name)
+	if err != nil {
+		return "", &os.PathError{Op: "hash", Path: name, Err: err}
+	}
+
+	return sum, nil
+}
+
+// sum returns the recorded SHA-256 sum of the file node, or computes it.
+func (n *node) sum(name string) (string, error) {
+	if n.hash != "" {
+		return n.hash, nil
+	}
+
+	f := n.open(name)
+	defer f.Close()
+
+	return hashReader(f)
+}
+
+// hashReader returns the hex encoded SHA-256 sum of the data read from r.
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,233 @@
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+type ioFS struct {
+	fs  *FileSystem
+	dir string
+}
+
+// noGlob hides the Glob method of ioFS, so that fs.Glob may be used to
+// implement it.
+type noGlob struct {
+	f ioFS
+}
+
+// FS returns a view of the filesystem that implements the io/fs interfaces,
+// to be used with packages such as html/template, or functions like
+// fs.WalkDir. Besides fs.FS, the returned value also implements fs.StatFS,
+// fs.ReadDirFS, fs.ReadFileFS, fs.GlobFS and fs.SubFS, as well as the
+// ReadLink and Lstat methods of fs.ReadLinkFS.
+//
+// Unlike the http.FileSystem methods, names passed to the view have to
+// satisfy fs.ValidPath.
+func (fs *FileSystem) FS() fs.FS {
+	return ioFS{fs, "."}
+}
+
+func (f ioFS) Open(name string) (fs.File, error) {
+	full, err := f.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.fs.Open(full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	return file, nil
+}
+
+func (f ioFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.fs.rlock() {
+		defer f.fs.mutex.RUnlock()
+	}
+
+	n, ok := f.fs.lookup(full)
+	if ok {
+		return n.stat, nil
+	}
+
+	if fallback := f.fs.fallback(); fallback != nil {
+		file, err := fallback.Open(rooted(full))
+		if err != nil {
+			return nil, pathError("stat", name, err)
+		}
+		defer file.Close()
+
+		return file.Stat()
+	}
+
+	return nil, pathError("stat", name, fs.ErrNotExist)
+}
+
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.fs.rlock() {
+		defer f.fs.mutex.RUnlock()
+	}
+
+	n, real, ok := f.fs.resolve(full, true)
+	if !ok {
+		if fallback := f.fs.fallback(); fallback != nil {
+			file, err := fallback.Open(rooted(full))
+			if err != nil {
+				return nil, pathError("readdir", name, err)
+			}
+			defer file.Close()
+
+			files, err := file.Readdir(-1)
+			if err != nil {
+				return nil, pathError("readdir", name, err)
+			}
+
+			sort.Slice(files, func(i, j int) bool {
+				return files[i].Name() < files[j].Name()
+			})
+
+			return dirEntries(files), nil
+		}
+
+		return nil, pathError("readdir", name, fs.ErrNotExist)
+	}
+
+	if !n.stat.IsDir() {
+		return nil, pathError("readdir", name, fs.ErrInvalid)
+	}
+
+	return dirEntries(f.fs.list(real, n)), nil
+}
+
+func (f ioFS) ReadFile(name string) ([]byte, error) {
+	full, err := f.resolve("read", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.fs.rlock() {
+		defer f.fs.mutex.RUnlock()
+	}
+
+	n, ok := f.fs.lookup(full)
+	if !ok {
+		if fallback := f.fs.fallback(); fallback != nil {
+			file, err := fallback.Open(rooted(full))
+			if err != nil {
+				return nil, pathError("read", name, err)
+			}
+			defer file.Close()
+
+			return io.ReadAll(file)
+		}
+
+		return nil, pathError("read", name, fs.ErrNotExist)
+	}
+
+	if n.stat.IsDir() {
+		return nil, pathError("read", name, fs.ErrInvalid)
+	}
+
+	b, err := n.bytes(full)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+
+	return b, nil
+}
+
+func (f ioFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(noGlob{f}, pattern)
+}
+
+func (f ioFS) Sub(dir string) (fs.FS, error) {
+	full, err := f.resolve("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioFS{f.fs, full}, nil
+}
+
+func (f ioFS) ReadLink(name string) (string, error) {
+	full, err := f.resolve("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := f.fs.Readlink(full)
+	if err != nil {
+		return "", pathError("readlink", name, err)
+	}
+
+	return target, nil
+}
+
+func (f ioFS) Lstat(name string) (fs.FileInfo, error) {
+	full, err := f.resolve("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.fs.Lstat(full)
+	if err != nil {
+		return nil, pathError("lstat", name, err)
+	}
+
+	return stat, nil
+}
+
+// resolve validates the given name and joins it with the view's root
+// directory.
+func (f ioFS) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", pathError(op, name, fs.ErrInvalid)
+	}
+
+	return path.Join(f.dir, name), nil
+}
+
+func (g noGlob) Open(name string) (fs.File, error) {
+	return g.f.Open(name)
+}
+
+func (g noGlob) Stat(name string) (fs.FileInfo, error) {
+	return g.f.Stat(name)
+}
+
+func (g noGlob) ReadDir(name string) ([]fs.DirEntry, error) {
+	return g.f.ReadDir(name)
+}
+
+func dirEntries(files []fs.FileInfo) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, fs.FileInfoToDirEntry(file))
+	}
+
+	return entries
+}
+
+// pathError converts err into an *fs.PathError for the given operation and
+// name, discarding any path information the error already carries.
+func pathError(op, name string, err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		err = pe.Err
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,92 @@
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	fsys := New()
+
+	for _, f := range files {
+		if f.name != "" {
+			if err := fsys.Add(f.name, f.stat.Size(), f.stat.Mode(), f.stat.ModTime(), f.data); err != nil {
+				t.Fatalf("didn't expect error %+v", err)
+			}
+		}
+	}
+
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("compressed data"))
+	w.Close()
+
+	if err := fsys.Add("d/zeta", 15, 0644, now, buf.String(), Gzipped()); err != nil {
+		t.Fatalf("didn't expect error %+v", err)
+	}
+
+	if err := fstest.TestFS(fsys.FS(), "foo", "bar", "d/alpha", "d/beta", "d/gamma", "d/zeta"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"foo", true},
+		{"d/alpha", true},
+		{"/foo", false},
+		{"./foo", false},
+		{"d/../foo", false},
+		{"d/", false},
+		{"", false},
+	}
+
+	for _, tc := range cases {
+		_, err := fs.Stat(fsys.FS(), tc.name)
+		if tc.valid {
+			if err != nil {
+				t.Fatalf("stat %s: %+v", tc.name, err)
+			}
+		} else {
+			if _, ok := err.(*fs.PathError); !ok {
+				t.Fatalf("expected *fs.PathError for %q, got %+v", tc.name, err)
+			}
+		}
+	}
+
+	sub, err := fs.Sub(fsys.FS(), "d")
+	if err != nil {
+		t.Fatalf("sub: %+v", err)
+	}
+
+	if err := fstest.TestFS(sub, "alpha", "beta", "gamma", "zeta"); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := fs.Glob(fsys.FS(), "d/*a")
+	if err != nil {
+		t.Fatalf("glob: %+v", err)
+	}
+
+	if len(matches) != 4 {
+		t.Fatalf("expected 4 matches, got %v", matches)
+	}
+
+	b, err := fs.ReadFile(fsys.FS(), "d/beta")
+	if err != nil {
+		t.Fatalf("read file: %+v", err)
+	}
+
+	if string(b) != "98765432" {
+		t.Fatalf("expected data %s, got %s", "98765432", string(b))
+	}
+
+	if _, err := fs.ReadFile(fsys.FS(), "missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected ErrNotExist, got %+v", err)
+	}
+}
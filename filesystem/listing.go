@@ -0,0 +1,108 @@
+package filesystem
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+)
+
+// A ListingFunc serves the named directory, which doesn't contain an
+// index.html file. The directory's files are sorted by name.
+type ListingFunc func(w http.ResponseWriter, r *http.Request, name string, files []os.FileInfo)
+
+// DirListing is the data passed to the template of a TemplateListing.
+type DirListing struct {
+	Name  string
+	Files []os.FileInfo
+}
+
+// NotFoundListing is a ListingFunc that hides directories by responding with
+// 404 Not Found.
+func NotFoundListing(w http.ResponseWriter, r *http.Request, name string, files []os.FileInfo) {
+	http.NotFound(w, r)
+}
+
+// TemplateListing creates a ListingFunc that renders directories using the
+// given template, executed with a DirListing value.
+func TemplateListing(t *template.Template) ListingFunc {
+	return func(w http.ResponseWriter, r *http.Request, name string, files []os.FileInfo) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if err := t.Execute(w, DirListing{name, files}); err != nil {
+			http.Error(w, "Error rendering directory listing", http.StatusInternalServerError)
+		}
+	}
+}
+
+// NoListing wraps an http.FileSystem, such as a FileSystem, so that
+// directories without an index.html file cannot be opened. When used with an
+// http.FileServer, such directories result in a 404 Not Found response
+// instead of a listing of their contents.
+func NoListing(fs http.FileSystem) http.FileSystem {
+	return noListing{fs}
+}
+
+type noListing struct {
+	fs http.FileSystem
+}
+
+func (n noListing) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if stat.IsDir() {
+		index, err := n.fs.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+
+		index.Close()
+	}
+
+	return f, nil
+}
+
+// serveListing serves the named directory with the handler's Listing
+// function, and reports whether it did so.
+func (h *Handler) serveListing(w http.ResponseWriter, r *http.Request, name string) bool {
+	// An index.html file only available through the fallback filesystem is
+	// served by the file server
+	if index, err := h.fs.Open(path.Join(name, "index.html")); err == nil {
+		index.Close()
+		return false
+	}
+
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	if stat, err := f.Stat(); err != nil || !stat.IsDir() {
+		return false
+	}
+
+	files, err := f.Readdir(-1)
+	if err != nil {
+		return false
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	h.Listing(w, r, name, files)
+
+	return true
+}
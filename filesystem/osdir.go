@@ -0,0 +1,222 @@
+package filesystem
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Dir implements http.FileSystem by opening files from the operating
+// system, confined to the directory tree rooted at the named directory. It
+// may be used as a FileSystem's FallbackFS.
+//
+// Unlike http.Dir, which only cleans the requested names, a Dir also resolves
+// symbolic links, and refuses to open any name that leads outside of the
+// root directory by returning an *os.PathError wrapping a *TraversalError,
+// which matches os.ErrPermission.
+type Dir string
+
+// A TraversalError is wrapped by the error returned when a requested name
+// refers to a location outside of the root directory of a Dir. It matches
+// os.ErrPermission, so that an http.FileServer responds with 403 Forbidden.
+type TraversalError struct {
+	Root string
+	Name string
+}
+
+func (e *TraversalError) Error() string {
+	return "filesystem: " + e.Name + " is outside of " + e.Root
+}
+
+// Is reports whether the target is os.ErrPermission.
+func (e *TraversalError) Is(target error) bool {
+	return target == os.ErrPermission
+}
+
+// Open opens the named file relative to the root directory. Absolute names
+// are treated as relative to the root directory as well.
+func (d Dir) Open(name string) (http.File, error) {
+	real, err := d.realPath("open", clean(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(real)
+}
+
+// Lstat returns the file information of the named file relative to the root
+// directory. If the file is a symbolic link, the information describes the
+// link, which isn't followed, while the directories leading to it are
+// resolved as with Open.
+func (d Dir) Lstat(name string) (os.FileInfo, error) {
+	p := clean(name)
+
+	dir, err := d.realPath("lstat", path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Lstat(filepath.Join(dir, path.Base(p)))
+}
+
+// realPath returns the real path of the cleaned name within the root
+// directory, or an error wrapping a *TraversalError, for the given operation,
+// if it leads outside of it.
+func (d Dir) realPath(op, name string) (string, error) {
+	root := string(d)
+	if root == "" {
+		root = "."
+	}
+
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", &os.PathError{Op: op, Path: name, Err: &TraversalError{root, name}}
+	}
+
+	realRoot, err := realPath(root)
+	if err != nil {
+		return "", err
+	}
+
+	real, err := realPath(filepath.Join(realRoot, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+
+	if rel, err := filepath.Rel(realRoot, real); err != nil || !local(rel) {
+		return "", &os.PathError{Op: op, Path: name, Err: &TraversalError{root, name}}
+	}
+
+	return real, nil
+}
+
+// A Source implements http.FileSystem by opening files from the operating
+// system that are named by the same arguments as those of the embed command,
+// such as "index.html", "static" or "assets/...". A directory includes its
+// files, or with a "/..." suffix its whole tree, and the parents of the named
+// files may be opened as well, although their listings aren't restricted.
+// Files of absolute names, and of names starting with "..", are opened under
+// the cleaned names the embed command gives them, e.g. "/srv/assets" as
+// srv/assets, and "../shared" as ../shared, relative to Root's parent.
+// It is used by the development files generated with the embed command's
+// -dev-output flag, so that files are served live from where they were
+// embedded.
+type Source struct {
+	// Root is the directory the names are relative to.
+	Root Dir
+
+	// Names restricts the files that may be opened. All files within Root
+	// may be opened if it is empty.
+	Names []string
+
+	// Fallback, when set, is used to open any file that isn't named.
+	Fallback http.FileSystem
+}
+
+// Open opens the named file from the root directory, if it is among the
+// source names.
+func (s Source) Open(name string) (http.File, error) {
+	p := clean(name)
+
+	d, rel, allowed, filesOnly := s.locate(p)
+	if !allowed {
+		if s.Fallback != nil {
+			return s.Fallback.Open(name)
+		}
+
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	f, err := d.Open(rel)
+	if err != nil || !filesOnly {
+		return f, err
+	}
+
+	// Subdirectories of directories that aren't recursive aren't included
+	if stat, err := f.Stat(); err != nil || stat.IsDir() {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+// locate reports whether the cleaned name is among the source names, and
+// whether it may only be a file, along with the directory it is opened from
+// and its name within it.
+func (s Source) locate(name string) (d Dir, rel string, allowed, filesOnly bool) {
+	if len(s.Names) == 0 {
+		return s.Root, name, true, false
+	}
+
+	var source string
+	for _, n := range s.Names {
+		recursive := strings.HasSuffix(n, "/...")
+		if recursive {
+			n = n[:len(n)-4]
+		}
+
+		c := clean(n)
+
+		switch {
+		case name == c, recursive && (c == "." || strings.HasPrefix(name, c+"/")):
+			d, rel = s.base(n, name)
+			return d, rel, true, false
+		case name == ".":
+			return s.Root, name, true, false
+		case strings.HasPrefix(c, name+"/"):
+			// A parent of the named file
+			d, rel = s.base(n, name)
+			return d, rel, true, false
+		case path.Dir(name) == c && !allowed:
+			source, allowed, filesOnly = n, true, true
+		}
+	}
+
+	if allowed {
+		d, rel = s.base(source, name)
+	}
+
+	return d, rel, allowed, filesOnly
+}
+
+// base returns the directory the cleaned name of a file of the given source
+// is opened from, and its name within it. Names of absolute sources are
+// opened from the root of the operating system's filesystem, and those
+// starting with ".." from the corresponding parent of Root, since the embed
+// command names the files it embeds after its arguments.
+func (s Source) base(source, name string) (Dir, string) {
+	if path.IsAbs(filepath.ToSlash(source)) {
+		return Dir("/"), name
+	}
+
+	root := string(s.Root)
+	if root == "" {
+		root = "."
+	}
+
+	for name == ".." || strings.HasPrefix(name, "../") {
+		root = filepath.Join(root, "..")
+		name = clean(strings.TrimPrefix(name[2:], "/"))
+	}
+
+	return Dir(root), name
+}
+
+// realPath returns the absolute path of name, with all symbolic links
+// resolved.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+// local reports whether the relative OS path doesn't escape its base.
+func local(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	return rel != ".." && !strings.HasPrefix(rel, "../") && !path.IsAbs(rel)
+}
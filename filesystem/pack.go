@@ -0,0 +1,491 @@
+package filesystem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A pack stores files in a single blob of data, preceded by an index of
+// records sorted by name. The layout, using little endian integers, is:
+//
+//	header    magic "EMBEDPK", version uint8, record count uint32,
+//	          manifest count uint32, blob offset uint64
+//	offsets   record count + 1 uint32 offsets of the records, the last one
+//	          being that of the manifest
+//	records   name length uint16, name, mode uint32, modification time
+//	          seconds int64 and nanoseconds uint32, size int64, data offset
+//	          within the blob uint64, data length uint64, flags uint8,
+//	          hash length uint8, hash, content type length uint8, content type
+//	manifest  name length uint16, name, alias length uint16, alias
+//	blob      the concatenated file data
+//	trailer   length of the preceding data uint64, magic "EMBEDPK",
+//	          version uint8
+//
+// Since the records are sorted, a file is found with a binary search of the
+// index, and loading a pack only requires reading its header and manifest.
+// The trailer allows finding a pack at the end of another file, such as an
+// executable.
+type pack struct {
+	// r reads the pack, unless it is held in memory as data.
+	r        io.ReaderAt
+	closer   io.Closer
+	data     string
+	size     int64
+	count    int
+	manifest int
+	blob     int64
+}
+
+type packRecord struct {
+	name   string
+	stat   info
+	offset int64
+	length int64
+	flags  uint8
+	hash   string
+	ctype  string
+}
+
+const (
+	packMagic       = "EMBEDPK"
+	packVersion     = 1
+	packHeaderSize  = 24
+	packTrailerSize = 16
+)
+
+// Flags of pack records.
+const (
+	packGzip = 1 << iota
+	packImmutable
+)
+
+var (
+	// ErrPackFormat is returned when loading data that isn't a valid pack.
+	ErrPackFormat = errors.New("filesystem: invalid pack format")
+
+	// ErrPackVersion is returned when loading a pack written by an
+	// incompatible version of the package.
+	ErrPackVersion = errors.New("filesystem: unsupported pack version")
+)
+
+// LoadPack creates a FileSystem from pack data, as produced by WritePack, or
+// by the embed command with the -pack flag. Only the header of the pack is
+// read upfront, while files are looked up in its index when opened, and their
+// data is sliced out of it without being copied.
+//
+// Files added to the filesystem later take precedence over packed ones with
+// the same name. Packed files can't be removed or renamed.
+func LoadPack(data string) (*FileSystem, error) {
+	p, err := newPack(nil, data, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := New()
+	if err := fs.addPack(p); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// WritePack writes the contents of the filesystem to w in the pack format
+// read by LoadPack. Files are stored as they are, so gzip compressed files
+// remain compressed, and identical data is only stored once. Fingerprinted
+// aliases and the manifest are preserved, while files that are only
+// available through a fallback are not written.
+func (fs *FileSystem) WritePack(w io.Writer) error {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	type blobKey struct {
+		data string
+		gzip bool
+	}
+
+	var records []packRecord
+	var blob []string
+	offsets := map[blobKey]int64{}
+	var size int64
+
+	err := fs.walk(".", fs.root, func(name string, n *node) error {
+		if len(name) > 0xffff || len(n.hash) > 0xff || len(n.ctype) > 0xff {
+			return &os.PathError{Op: "pack", Path: name, Err: os.ErrInvalid}
+		}
+
+		rec := packRecord{name: name, stat: n.stat, hash: n.hash, ctype: n.ctype}
+		if n.immutable {
+			rec.flags |= packImmutable
+		}
+
+		if n.stat.IsDir() {
+			records = append(records, rec)
+			return nil
+		}
+
+		data := n.data
+		if n.stream != nil {
+			b, err := n.stored()
+			if err != nil {
+				return &os.PathError{Op: "pack", Path: name, Err: err}
+			}
+			data = string(b)
+		}
+
+		if n.gzip {
+			rec.flags |= packGzip
+		}
+
+		key := blobKey{data, rec.flags&packGzip != 0}
+		offset, ok := offsets[key]
+		if !ok {
+			offset = size
+			offsets[key] = offset
+			blob = append(blob, data)
+			size += int64(len(data))
+		}
+
+		rec.offset, rec.length = offset, int64(len(data))
+		records = append(records, rec)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].name < records[j].name
+	})
+
+	manifest := make([]string, 0, len(fs.manifest))
+	for name := range fs.manifest {
+		manifest = append(manifest, name)
+	}
+	sort.Strings(manifest)
+
+	var index bytes.Buffer
+	recordOffsets := make([]uint32, 0, len(records)+1)
+	base := packHeaderSize + 4*int64(len(records)+1)
+
+	for _, rec := range records {
+		recordOffsets = append(recordOffsets, uint32(base+int64(index.Len())))
+
+		writeString(&index, rec.name, 2)
+		writeInt(&index, uint64(rec.stat.mode), 4)
+		writeInt(&index, uint64(rec.stat.modTime.Unix()), 8)
+		writeInt(&index, uint64(rec.stat.modTime.Nanosecond()), 4)
+		writeInt(&index, uint64(rec.stat.size), 8)
+		writeInt(&index, uint64(rec.offset), 8)
+		writeInt(&index, uint64(rec.length), 8)
+		writeInt(&index, uint64(rec.flags), 1)
+		writeString(&index, rec.hash, 1)
+		writeString(&index, rec.ctype, 1)
+	}
+
+	recordOffsets = append(recordOffsets, uint32(base+int64(index.Len())))
+
+	for _, name := range manifest {
+		writeString(&index, name, 2)
+		writeString(&index, fs.manifest[name], 2)
+	}
+
+	blobOffset := base + int64(index.Len())
+	if blobOffset > 0xffffffff {
+		return ErrPackFormat
+	}
+
+	var header bytes.Buffer
+	header.WriteString(packMagic)
+	writeInt(&header, packVersion, 1)
+	writeInt(&header, uint64(len(records)), 4)
+	writeInt(&header, uint64(len(manifest)), 4)
+	writeInt(&header, uint64(blobOffset), 8)
+
+	for _, offset := range recordOffsets {
+		writeInt(&header, uint64(offset), 4)
+	}
+
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+
+	if _, err := index.WriteTo(w); err != nil {
+		return err
+	}
+
+	for _, data := range blob {
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+
+	var trailer bytes.Buffer
+	writeInt(&trailer, uint64(blobOffset+size), 8)
+	trailer.WriteString(packMagic)
+	writeInt(&trailer, packVersion, 1)
+
+	_, err = trailer.WriteTo(w)
+
+	return err
+}
+
+// addPack makes the files of the pack available through the filesystem, and
+// merges its manifest.
+func (fs *FileSystem) addPack(p *pack) error {
+	d := p.decoder(packHeaderSize+4*int64(p.count), 4)
+	start := int64(d.int(4))
+
+	d = p.decoder(start, p.blob-start)
+
+	manifest := make(map[string]string, p.manifest)
+	for i := 0; i < p.manifest; i++ {
+		name := d.string(2)
+		manifest[name] = d.string(2)
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.packs = append(fs.packs, p)
+	fs.packed = &sync.Map{}
+
+	// The listings of the tree include packed files
+	fs.register(".", fs.root)
+	for name, alias := range manifest {
+		fs.manifest[name] = alias
+	}
+
+	return nil
+}
+
+// newPack reads the header of a pack, held either in data, or read from r.
+func newPack(r io.ReaderAt, data string, size int64) (*pack, error) {
+	p := &pack{r: r, data: data, size: size}
+
+	d := p.decoder(0, packHeaderSize)
+	if d.err != nil || d.next(len(packMagic)) != packMagic {
+		return nil, ErrPackFormat
+	}
+
+	if d.int(1) != packVersion {
+		return nil, ErrPackVersion
+	}
+
+	p.count = int(d.int(4))
+	p.manifest = int(d.int(4))
+	p.blob = int64(d.int(8))
+
+	if p.blob < packHeaderSize+4*int64(p.count+1) || p.blob > size {
+		return nil, ErrPackFormat
+	}
+
+	return p, nil
+}
+
+// lookup returns a node for the named file, if the pack contains it.
+func (p *pack) lookup(name string) (*node, bool) {
+	i := p.search(name)
+	if i == p.count {
+		return nil, false
+	}
+
+	rec, err := p.record(i)
+	if err != nil || rec.name != name {
+		return nil, false
+	}
+
+	return p.node(rec), true
+}
+
+// children returns nodes for the direct children of the named directory,
+// sorted by name.
+func (p *pack) children(name string) []*node {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	var nodes []*node
+	for i := p.search(prefix); i < p.count; {
+		rec, err := p.record(i)
+		if err != nil || !strings.HasPrefix(rec.name, prefix) {
+			break
+		}
+
+		rest := rec.name[len(prefix):]
+		if j := strings.IndexByte(rest, '/'); j != -1 {
+			// Skipping past the contents of the grandchild, as '0' is the
+			// character following '/'
+			i = p.search(prefix + rest[:j] + "0")
+			continue
+		}
+
+		nodes = append(nodes, p.node(rec))
+		i++
+	}
+
+	return nodes
+}
+
+// search returns the index of the first record whose name isn't less than
+// the given one.
+func (p *pack) search(name string) int {
+	return sort.Search(p.count, func(i int) bool {
+		rec, err := p.record(i)
+		return err != nil || rec.name >= name
+	})
+}
+
+// record decodes the record at index i.
+func (p *pack) record(i int) (packRecord, error) {
+	d := p.decoder(packHeaderSize+4*int64(i), 8)
+	start, end := int64(d.int(4)), int64(d.int(4))
+	if d.err != nil {
+		return packRecord{}, d.err
+	}
+
+	d = p.decoder(start, end-start)
+
+	rec := packRecord{name: d.string(2)}
+	rec.stat.name = path.Base(rec.name)
+	rec.stat.mode = os.FileMode(d.int(4))
+	sec, nsec := int64(d.int(8)), int64(d.int(4))
+	rec.stat.modTime = time.Unix(sec, nsec)
+	rec.stat.size = int64(d.int(8))
+	rec.offset = int64(d.int(8))
+	rec.length = int64(d.int(8))
+	rec.flags = uint8(d.int(1))
+	rec.hash = d.string(1)
+	rec.ctype = d.string(1)
+
+	return rec, d.err
+}
+
+// node creates a node for the record. The data of packs held in memory is
+// sliced out of the blob, while that of others is read on demand.
+func (p *pack) node(rec packRecord) *node {
+	if rec.stat.IsDir() {
+		return &node{name: rec.stat.name, children: map[string]*node{}, stat: rec.stat}
+	}
+
+	n := &node{
+		name:      rec.stat.name,
+		stat:      rec.stat,
+		gzip:      rec.flags&packGzip != 0,
+		hash:      rec.hash,
+		ctype:     rec.ctype,
+		immutable: rec.flags&packImmutable != 0,
+	}
+
+	// The targets of symbolic links are needed for resolving names
+	if p.r == nil || rec.stat.mode&os.ModeSymlink != 0 {
+		n.data = p.decoder(p.blob+rec.offset, rec.length).next(int(rec.length))
+		return n
+	}
+
+	r := p.r
+	offset := p.blob + rec.offset
+	if offset < 0 || rec.length < 0 || offset+rec.length > p.size {
+		offset, rec.length = 0, 0
+	}
+
+	n.stream = func() (io.ReadCloser, error) {
+		return section{io.NewSectionReader(r, offset, rec.length)}, nil
+	}
+
+	return n
+}
+
+// section streams the stored data of a file from a pack. Unlike other
+// streams, it can be seeked, so that a Handler may serve it as it is.
+type section struct {
+	*io.SectionReader
+}
+
+func (section) Close() error {
+	return nil
+}
+
+// decoder returns a packDecoder for length bytes of the pack, starting at
+// the offset.
+func (p *pack) decoder(offset, length int64) *packDecoder {
+	if offset < 0 || length < 0 || offset+length > p.size {
+		return &packDecoder{err: ErrPackFormat}
+	}
+
+	if p.r == nil {
+		return &packDecoder{s: p.data[offset : offset+length]}
+	}
+
+	b := make([]byte, length)
+	if _, err := p.r.ReadAt(b, offset); err != nil {
+		return &packDecoder{err: err}
+	}
+
+	return &packDecoder{s: string(b)}
+}
+
+// packDecoder reads consecutive values from a part of a pack. Once an error
+// has occurred, all values are empty.
+type packDecoder struct {
+	s   string
+	err error
+}
+
+func (d *packDecoder) next(n int) string {
+	if d.err == nil && len(d.s) < n {
+		d.err = ErrPackFormat
+	}
+
+	if d.err != nil {
+		return ""
+	}
+
+	v := d.s[:n]
+	d.s = d.s[n:]
+
+	return v
+}
+
+// int reads a little endian unsigned integer of n bytes.
+func (d *packDecoder) int(n int) uint64 {
+	var v uint64
+	s := d.next(n)
+	for i := 0; i < len(s); i++ {
+		v |= uint64(s[i]) << (8 * i)
+	}
+
+	return v
+}
+
+// string reads a string preceded by its length, as an n byte integer.
+func (d *packDecoder) string(n int) string {
+	return d.next(int(d.int(n)))
+}
+
+// writeInt writes v as a little endian integer of n bytes.
+func writeInt(b *bytes.Buffer, v uint64, n int) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	b.Write(buf[:n])
+}
+
+// writeString writes s preceded by its length, as an n byte integer.
+func writeString(b *bytes.Buffer, s string, n int) {
+	writeInt(b, uint64(len(s)), n)
+	b.WriteString(s)
+}
@@ -0,0 +1,109 @@
+package filesystem
+
+import (
+	"io"
+	"os"
+)
+
+// OpenPack creates a FileSystem from the pack at the end of the named file,
+// which may either be a pack written by WritePack or the embed command's
+// -pack-file flag, or any other file with such a pack appended to it. Only
+// the header of the pack is read upfront, and file data is read from the
+// open file on demand, until Close is called.
+//
+// Gzip compressed files remain compressed in the pack file, and are
+// decompressed as they are read, while a Handler streams them as they are to
+// clients that accept the gzip content encoding.
+func OpenPack(name string) (*FileSystem, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := New()
+	if err := fs.openPack(f); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return fs, nil
+}
+
+// OpenSelf creates a FileSystem from a pack appended to the executable of the
+// current process, as with OpenPack. The pack may be appended after the
+// executable has been built, e.g.:
+//
+//	embed -pack-file assets.pack assets/...
+//	cat assets.pack >> app
+func OpenSelf() (*FileSystem, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenPack(exe)
+}
+
+// Close closes the files of packs opened with OpenPack or OpenSelf, whose
+// files can't be read afterwards. It does nothing for other filesystems.
+func (fs *FileSystem) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	var err error
+	for _, p := range fs.packs {
+		if p.closer == nil {
+			continue
+		}
+
+		if cerr := p.closer.Close(); err == nil {
+			err = cerr
+		}
+		p.closer = nil
+	}
+
+	return err
+}
+
+// openPack locates the pack at the end of the file using its trailer, and
+// adds it to the filesystem.
+func (fs *FileSystem) openPack(f *os.File) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := stat.Size()
+	if size < packTrailerSize {
+		return ErrPackFormat
+	}
+
+	b := make([]byte, packTrailerSize)
+	if _, err := f.ReadAt(b, size-packTrailerSize); err != nil {
+		return err
+	}
+
+	d := &packDecoder{s: string(b)}
+	length := int64(d.int(8))
+	if d.next(len(packMagic)) != packMagic {
+		return ErrPackFormat
+	}
+
+	if d.int(1) != packVersion {
+		return ErrPackVersion
+	}
+
+	start := size - packTrailerSize - length
+	if start < 0 || length < 0 {
+		return ErrPackFormat
+	}
+
+	p, err := newPack(io.NewSectionReader(f, start, length), "", length)
+	if err != nil {
+		return err
+	}
+
+	p.closer = f
+
+	return fs.addPack(p)
+}
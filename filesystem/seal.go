@@ -0,0 +1,41 @@
+package filesystem
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrSealed is returned when modifying a sealed filesystem.
+var ErrSealed = errors.New("filesystem: sealed")
+
+// Seal makes the filesystem immutable. Methods that modify it fail with an
+// error wrapping ErrSealed afterwards, while methods that read it no longer
+// lock it, which avoids contention when serving files from many goroutines.
+// Packs may still be closed, and the exported fields may still be set, as
+// long as that doesn't happen concurrently with reading.
+//
+// The functions generated by the embed command seal the filesystems they
+// create when it is run with -seal.
+func (fs *FileSystem) Seal() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	atomic.StoreInt32(&fs.sealed, 1)
+}
+
+// Sealed reports whether Seal has been called.
+func (fs *FileSystem) Sealed() bool {
+	return atomic.LoadInt32(&fs.sealed) != 0
+}
+
+// rlock takes the read lock, unless the filesystem is sealed, and reports
+// whether it did so, in which case the caller has to release it.
+func (fs *FileSystem) rlock() bool {
+	if fs.Sealed() {
+		return false
+	}
+
+	fs.mutex.RLock()
+
+	return true
+}
@@ -0,0 +1,104 @@
+package filesystem
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// An SPAHandler serves a single-page application from a FileSystem. Existing
+// files are served the same way as with a Handler, while requests for any
+// other path, or for directories, are answered with a fallback document, so
+// that the application's client-side routing can handle them.
+//
+// Since the fallback document is served under many paths, and usually refers
+// to fingerprinted assets, it is served with a Cache-Control header that
+// requires revalidation, while fingerprinted aliases may be cached for a
+// year.
+type SPAHandler struct {
+	// Index is the name of the fallback document. It is /index.html by
+	// default.
+	Index string
+
+	// Exclude holds path prefixes, such as /static, under which missing
+	// files result in a 404 Not Found response rather than the fallback
+	// document.
+	Exclude []string
+
+	// IndexCacheControl is the Cache-Control header value of the fallback
+	// document. It is no-cache by default.
+	IndexCacheControl string
+
+	fs      *FileSystem
+	handler *Handler
+}
+
+// NewSPAHandler creates an SPAHandler serving the files of the given
+// FileSystem.
+func NewSPAHandler(fs *FileSystem) *SPAHandler {
+	return &SPAHandler{
+		Index:             "/index.html",
+		IndexCacheControl: "no-cache",
+		fs:                fs,
+		handler:           NewHandler(fs),
+	}
+}
+
+func (h *SPAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+
+	// The index is served with the same headers under its own name
+	if name == path.Clean("/"+h.Index) {
+		h.serveIndex(w, r)
+		return
+	}
+
+	if f, err := h.fs.Open(name); err == nil {
+		stat, err := f.Stat()
+		f.Close()
+
+		if err == nil && !stat.IsDir() {
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	for _, prefix := range h.Exclude {
+		prefix = path.Clean("/" + prefix)
+		if name == prefix || strings.HasPrefix(name, strings.TrimSuffix(prefix, "/")+"/") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	h.serveIndex(w, r)
+}
+
+func (h *SPAHandler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	index := path.Clean("/" + h.Index)
+
+	if _, ok := w.Header()["Cache-Control"]; h.IndexCacheControl != "" && !ok {
+		w.Header().Set("Cache-Control", h.IndexCacheControl)
+	}
+
+	if h.handler.serveFile(w, r, index) {
+		return
+	}
+
+	// The fallback document might only be available through the fallback
+	// filesystem
+	f, err := h.fs.Open(index)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, index, stat.ModTime(), f)
+}
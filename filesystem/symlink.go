@@ -0,0 +1,165 @@
+package filesystem
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxLinks is the number of symbolic links that are resolved for a single
+// name, beyond which they are considered to form a loop.
+const maxLinks = 40
+
+// AddSymlink inserts a symbolic link to the target into the filesystem.
+// Relative targets are resolved from the directory of the link, and absolute
+// ones from the root of the filesystem.
+//
+// Links are followed when opening files, up to a limit which guards against
+// loops. Links that are dangling, form a loop, or lead outside of the root of
+// the filesystem, behave as if they didn't exist, apart from Lstat and
+// Readlink, which describe the links themselves.
+func (fs *FileSystem) AddSymlink(name, target string, modTime time.Time) error {
+	return fs.Add(name, int64(len(target)), os.ModeSymlink|0777, modTime, target)
+}
+
+// Lstat returns the file information of the named file. If the file is a
+// symbolic link, the information describes the link, which isn't followed.
+// The same holds for files of a fallback that is a Dir or an http.Dir, while
+// those of any other fallback are opened, and thus followed.
+func (fs *FileSystem) Lstat(name string) (os.FileInfo, error) {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	n, ok := fs.lookupLink(clean(name))
+	if ok {
+		return n.stat, nil
+	}
+
+	if fallback := fs.fallback(); fallback != nil {
+		stat, err := lstat(fallback, rooted(clean(name)))
+		if err != nil {
+			if pe, ok := err.(*os.PathError); ok {
+				err = pe.Err
+			}
+
+			return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+		}
+
+		return stat, nil
+	}
+
+	return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+}
+
+// lstat returns the file information of the named file of the fallback,
+// without following it if the fallback is a directory of the operating
+// system.
+func lstat(fallback http.FileSystem, name string) (os.FileInfo, error) {
+	switch d := fallback.(type) {
+	case Dir:
+		return d.Lstat(name)
+	case http.Dir:
+		dir := string(d)
+		if dir == "" {
+			dir = "."
+		}
+
+		return os.Lstat(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))))
+	}
+
+	f, err := fallback.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// Readlink returns the target of the named symbolic link. The fallback isn't
+// consulted.
+func (fs *FileSystem) Readlink(name string) (string, error) {
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	n, ok := fs.lookupLink(clean(name))
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+
+	if n.stat.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+
+	return n.data, nil
+}
+
+// lookup returns the node for the given cleaned name, following symbolic
+// links. The caller is expected to hold the read lock.
+func (fs *FileSystem) lookup(name string) (*node, bool) {
+	n, _, ok := fs.resolve(name, true)
+	return n, ok
+}
+
+// lookupLink returns the node for the given cleaned name, following symbolic
+// links, except for the last element of the name. The caller is expected to
+// hold the read lock.
+func (fs *FileSystem) lookupLink(name string) (*node, bool) {
+	n, _, ok := fs.resolve(name, false)
+	return n, ok
+}
+
+// resolve returns the node for the given cleaned name, along with its name
+// once all symbolic links have been resolved. The last element of the name
+// is only resolved if follow is set.
+func (fs *FileSystem) resolve(name string, follow bool) (*node, string, bool) {
+	// Most names don't contain any links
+	if n, real, ok := fs.get(name); ok && (!follow || n.stat.mode&os.ModeSymlink == 0) {
+		return n, real, true
+	}
+
+	parts := strings.Split(name, "/")
+	current := "."
+	links := 0
+
+	for i := 0; i < len(parts); i++ {
+		if parts[i] == "." {
+			continue
+		}
+
+		n, next, ok := fs.get(path.Join(current, parts[i]))
+		if !ok {
+			return nil, "", false
+		}
+
+		if n.stat.mode&os.ModeSymlink == 0 || !follow && i == len(parts)-1 {
+			current = next
+			continue
+		}
+
+		if links++; links > maxLinks {
+			return nil, "", false
+		}
+
+		target := n.data
+		if !path.IsAbs(target) {
+			target = path.Join(current, target)
+		}
+
+		target = clean(target)
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return nil, "", false
+		}
+
+		// Continuing from the root with the target in place of the link
+		parts = append(strings.Split(target, "/"), parts[i+1:]...)
+		current, i = ".", -1
+	}
+
+	return fs.get(current)
+}
@@ -0,0 +1,191 @@
+package filesystem
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A WalkFunc is called by Walk for each file and directory, with the same
+// meaning as a filepath.WalkFunc, apart from names being slash separated.
+type WalkFunc func(name string, info os.FileInfo, err error) error
+
+// Walk calls fn for the file or directory named root, and for every file and
+// directory within it, in lexical order. The names passed to fn are joined
+// with root. If fn returns filepath.SkipDir for a directory, its contents are
+// skipped, while for a file, the remaining files of its directory are.
+//
+// The root is resolved as with Open, but symbolic links within it aren't
+// followed, and the fallback isn't consulted. The filesystem isn't locked
+// while fn is called, so it may open or add files.
+func (fs *FileSystem) Walk(root string, fn WalkFunc) error {
+	locked := fs.rlock()
+	n, real, ok := fs.resolve(clean(root), true)
+	var stat os.FileInfo
+	if ok {
+		stat = n.stat
+	}
+	if locked {
+		fs.mutex.RUnlock()
+	}
+
+	var err error
+	if !ok {
+		err = fn(root, nil, &os.PathError{Op: "walk", Path: root, Err: os.ErrNotExist})
+	} else {
+		err = fs.walkDir(root, real, n, stat, fn)
+	}
+
+	if err == filepath.SkipDir {
+		return nil
+	}
+
+	return err
+}
+
+// walkDir calls fn for the node, and walks its children if it is a directory.
+// The real name of the node is used to list the packed files within it.
+func (fs *FileSystem) walkDir(name, real string, n *node, stat os.FileInfo, fn WalkFunc) error {
+	if err := fn(name, stat, nil); err != nil || !stat.IsDir() {
+		return err
+	}
+
+	locked := fs.rlock()
+	l := fs.listing(real, n)
+	if locked {
+		fs.mutex.RUnlock()
+	}
+
+	for i, c := range l.nodes {
+		base := l.files[i].Name()
+
+		err := fs.walkDir(path.Join(name, base), path.Join(real, base), c, l.files[i], fn)
+		if err == filepath.SkipDir {
+			if l.files[i].IsDir() {
+				continue
+			}
+
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Glob returns the names of all files and directories matching the pattern,
+// in lexical order, or nil if there are none. The syntax of the pattern is
+// that of path.Match, with the addition of "**" as a whole element of the
+// pattern, which matches any number of directories, including none, and as
+// the last element, everything within them. For example, "/views/**/*.html"
+// matches both /views/index.html and /views/admin/users/list.html.
+//
+// The returned names have a leading slash if the pattern does. Symbolic links
+// to directories are followed by elements other than "**", and the fallback
+// isn't consulted. The only possible error is path.ErrBadPattern.
+func (fs *FileSystem) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	if fs.rlock() {
+		defer fs.mutex.RUnlock()
+	}
+
+	var parts []string
+	if p := clean(pattern); p != "." {
+		parts = strings.Split(p, "/")
+	}
+
+	seen := map[string]bool{}
+	fs.glob(".", fs.root, parts, seen)
+
+	// The root itself isn't a match
+	delete(seen, ".")
+	if len(seen) == 0 {
+		return nil, nil
+	}
+
+	prefix := ""
+	if strings.HasPrefix(pattern, "/") {
+		prefix = "/"
+	}
+
+	matches := make([]string, 0, len(seen))
+	for name := range seen {
+		matches = append(matches, prefix+name)
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// glob records the names of the descendants of the named directory node that
+// match the remaining elements of the pattern. The caller is expected to hold
+// the read lock.
+func (fs *FileSystem) glob(name string, n *node, parts []string, matches map[string]bool) {
+	if len(parts) == 0 {
+		matches[name] = true
+		return
+	}
+
+	if !n.stat.IsDir() {
+		return
+	}
+
+	if parts[0] == "**" {
+		fs.glob(name, n, parts[1:], matches)
+
+		for _, c := range fs.children(name, n) {
+			if c.stat.IsDir() {
+				fs.glob(path.Join(name, c.name), c, parts, matches)
+			} else if len(parts) == 1 {
+				matches[path.Join(name, c.name)] = true
+			}
+		}
+
+		return
+	}
+
+	for _, c := range fs.children(name, n) {
+		if ok, _ := path.Match(parts[0], c.name); !ok {
+			continue
+		}
+
+		child := path.Join(name, c.name)
+		if len(parts) > 1 && c.stat.mode&os.ModeSymlink != 0 {
+			// Continuing within the linked directory, under the link's name
+			target, real, ok := fs.resolve(child, true)
+			if !ok {
+				continue
+			}
+
+			fs.globLink(child, real, target, parts[1:], matches)
+			continue
+		}
+
+		fs.glob(child, c, parts[1:], matches)
+	}
+}
+
+// globLink matches the remaining elements of the pattern within the target of
+// a symbolic link, recording the names of the matches under the link's name.
+func (fs *FileSystem) globLink(name, real string, n *node, parts []string, matches map[string]bool) {
+	found := map[string]bool{}
+	fs.glob(real, n, parts, found)
+
+	for m := range found {
+		switch {
+		case m == real:
+			matches[name] = true
+		case real == ".":
+			matches[path.Join(name, m)] = true
+		default:
+			matches[path.Join(name, m[len(real)+1:])] = true
+		}
+	}
+}